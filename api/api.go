@@ -2,11 +2,17 @@ package api
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"syscall"
 
 	"github.com/Robpol86/githubBackup/config"
@@ -41,69 +47,309 @@ func prompt(message, testTokenAnswer string) (input string, err error) {
 
 // API holds fields and functions related to querying the GitHub API.
 type API struct {
-	NoComments bool
-	NoForks    bool
-	NoIssues   bool
-	NoPrivate  bool
-	NoPublic   bool
-	NoReleases bool
-	NoWikis    bool
-	Token      string
-	User       string
+	APIURL        string
+	CacheDir      string // Cache API responses here. Disabled if empty.
+	UploadURL     string
+	Affiliation   string   // Only list the user's repos with these comma separated affiliations. Ignored with User.
+	CloneProtocol string   // https, ssh or auto (SSH for private repos). Empty is auto.
+	Excludes      []string // Skip repos and gists matching these --exclude patterns.
+	Includes      []string // Only keep repos and gists matching one of these --include patterns. All if empty.
+	Languages     []string // Only keep repos with one of these primary languages. All if empty.
+	Login         string   // The authenticated user's login. Looked up by GetRepos() without User if there's a Token.
+	MaxSize       int      // Skip repos larger than this many KB. No limit if 0.
+	NoArchived    bool
+	NoComments    bool
+	NoForks       bool
+	NoIssues      bool
+	NoPrivate     bool
+	NoPublic      bool
+	NoPulls       bool
+	NoReleases    bool
+	NoWikis       bool
+	Orgs          []string
+	Retries       int
+	Token         string
+	TokenSource   string   // Where the token came from. One of the TokenSource* constants, empty if there's no token.
+	Topics        []string // Only keep repos with one of these topics. All if empty.
+	User          string
 
 	TestURL string
 
 	github.Rate
+	Requests     int            // API responses received so far.
+	SkippedRepos map[string]int // Number of repos left out by the filterReason() filters, keyed by Skip* reason.
+	httpClient   *http.Client
 }
 
+// apiMu guards API fields updated while repos are backed up concurrently.
+var apiMu sync.Mutex
+
 // Fields is for logging. Returns the field name and values of the API struct as a logrus.Fields value.
 func (a *API) Fields() logrus.Fields {
 	return logrus.Fields{
-		"NoComments": a.NoComments,
-		"NoForks":    a.NoForks,
-		"NoIssues":   a.NoIssues,
-		"NoPrivate":  a.NoPrivate,
-		"NoPublic":   a.NoPublic,
-		"NoReleases": a.NoReleases,
-		"NoWikis":    a.NoWikis,
-		"TokenLen":   len(a.Token),
-		"User":       a.User,
+		"APIURL":        a.APIURL,
+		"UploadURL":     a.UploadURL,
+		"Affiliation":   a.Affiliation,
+		"CloneProtocol": a.CloneProtocol,
+		"Excludes":      a.Excludes,
+		"Includes":      a.Includes,
+		"Languages":     a.Languages,
+		"MaxSize":       a.MaxSize,
+		"NoArchived":    a.NoArchived,
+		"NoComments":    a.NoComments,
+		"NoForks":       a.NoForks,
+		"NoIssues":      a.NoIssues,
+		"NoPrivate":     a.NoPrivate,
+		"NoPublic":      a.NoPublic,
+		"NoPulls":       a.NoPulls,
+		"NoReleases":    a.NoReleases,
+		"NoWikis":       a.NoWikis,
+		"Orgs":          a.Orgs,
+		"Retries":       a.Retries,
+		"TokenLen":      len(a.Token),
+		"TokenSource":   a.TokenSource,
+		"Topics":        a.Topics,
+		"User":          a.User,
 	}
 }
 
-func (a *API) getClient() *github.Client {
-	var httpClient *http.Client
+// noteRate saves rate limiting info from the latest API response.
+func (a *API) noteRate(response *github.Response) {
+	apiMu.Lock()
+	defer apiMu.Unlock()
+	a.Limit = response.Limit
+	a.Remaining = response.Remaining
+	a.Reset = response.Reset
+	a.Requests++
+}
+
+// translateError replaces confusing error messages from the GitHub library with friendlier ones.
+func translateError(err error) error {
+	if strings.HasPrefix(err.Error(), "invalid character ") {
+		return errors.New("invalid JSON response from server")
+	}
+	return err
+}
+
+// writeJSON atomically writes pretty printed JSON to a file so an interrupted run doesn't leave partial files behind.
+func writeJSON(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(path+".tmp", append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// uploadURL returns the upload URL to use. GitHub Enterprise serves uploads from /api/uploads/ next to /api/v3/.
+func (a *API) uploadURL() string {
+	if a.UploadURL != "" || a.APIURL == "" {
+		return a.UploadURL
+	}
+	parsed, _ := config.ParseBaseURL("--api-url", a.APIURL)
+	parsed.Path = strings.TrimSuffix(parsed.Path, "v3/") + "uploads/"
+	return parsed.String()
+}
+
+// gitHost returns the host (and port if any) serving git repos, derived from --api-url.
+func (a *API) gitHost() string {
+	if a.APIURL == "" {
+		return "github.com"
+	}
+	apiURL, _ := config.ParseBaseURL("--api-url", a.APIURL)
+	return strings.TrimPrefix(apiURL.Host, "api.") // GitHub.com serves its API from a subdomain.
+}
+
+// cloneURL points a git clone URL (HTTPS, ssh:// or scp-like git@host:path) to the --api-url host. Prevents git from
+// talking to a different host than the API when GitHub Enterprise reports URLs with an internal or outdated hostname.
+// Gists served from their own gist. subdomain (gist.github.com, GitHub Enterprise with subdomain isolation) keep it.
+func (a *API) cloneURL(cloneURL string) string {
+	if a.APIURL == "" {
+		return cloneURL
+	}
+	apiURL, _ := config.ParseBaseURL("--api-url", a.APIURL)
+
+	// target returns the host (with port if any) and hostname to replace the original hostname with.
+	target := func(original string) (string, string) {
+		host := a.gitHost()
+		hostname := host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			hostname = h
+		}
+		if strings.HasPrefix(original, "gist.") && !strings.HasPrefix(hostname, "gist.") {
+			return "gist." + host, "gist." + hostname
+		}
+		return host, hostname
+	}
+
+	var rewritten string
+	if !strings.Contains(cloneURL, "://") {
+		// scp-like syntax: user@host:path
+		at := strings.Index(cloneURL, "@")
+		colon := strings.Index(cloneURL, ":")
+		if at < 0 || colon < at {
+			return cloneURL
+		}
+		_, hostname := target(cloneURL[at+1 : colon])
+		rewritten = cloneURL[:at+1] + hostname + cloneURL[colon:]
+	} else {
+		parsed, err := url.Parse(cloneURL)
+		if err != nil {
+			return cloneURL
+		}
+		original, port, err := net.SplitHostPort(parsed.Host)
+		if err != nil {
+			original = parsed.Host
+		}
+		host, hostname := target(original)
+		if parsed.Scheme == "http" || parsed.Scheme == "https" {
+			parsed.Scheme = apiURL.Scheme
+			parsed.Host = host
+		} else if port != "" {
+			parsed.Host = net.JoinHostPort(hostname, port)
+		} else {
+			parsed.Host = hostname
+		}
+		rewritten = parsed.String()
+	}
+
+	if rewritten != cloneURL {
+		config.GetLogger().WithField("from", cloneURL).WithField("to", rewritten).Debug("Rewrote clone URL host.")
+	}
+	return rewritten
+}
+
+// sshCloneURL converts an HTTPS clone URL to the scp-like SSH syntax (git@host:path) for --clone-protocol ssh.
+func sshCloneURL(cloneURL string) string {
+	parsed, err := url.Parse(cloneURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return cloneURL
+	}
+	hostname := parsed.Host
+	if h, _, err := net.SplitHostPort(hostname); err == nil {
+		hostname = h
+	}
+	return "git@" + hostname + ":" + strings.TrimPrefix(parsed.Path, "/")
+}
+
+// newHTTPClient builds the HTTP client stack: authentication, rate limit waits, caching and retries.
+func (a *API) newHTTPClient() *http.Client {
+	var transport http.RoundTripper = &retryTransport{base: http.DefaultTransport, retries: a.Retries}
+	if a.CacheDir != "" {
+		pruneCache(a.CacheDir)
+		transport = &cacheTransport{base: transport, dir: a.CacheDir}
+	}
+	httpClient := &http.Client{Transport: &rateLimitTransport{base: transport}}
 	if a.Token != "" {
 		tokenSource := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: a.Token})
-		httpClient = oauth2.NewClient(context.Background(), tokenSource)
+		ctx := context.WithValue(context.Background(), oauth2.HTTPClient, httpClient) // Wrap rate limit transport.
+		httpClient = oauth2.NewClient(ctx, tokenSource)
 	}
+	return httpClient
+}
+
+// sharedHTTPClient returns the HTTP client of the API, creating it on the first call.
+func (a *API) sharedHTTPClient() *http.Client {
+	apiMu.Lock()
+	defer apiMu.Unlock()
+	if a.httpClient == nil {
+		a.httpClient = a.newHTTPClient()
+	}
+	return a.httpClient
+}
+
+// getClient returns a GitHub client. All clients of the API share one HTTP client so concurrent backups share one rate
+// limit budget.
+func (a *API) getClient() *github.Client {
+	return a.newClient(a.sharedHTTPClient())
+}
+
+// getDownloadClient returns a GitHub client with its own copy of the shared HTTP client (same transport stack). For
+// DownloadReleaseAsset() which temporarily replaces the CheckRedirect policy of the HTTP client while it's in use.
+func (a *API) getDownloadClient() *github.Client {
+	httpClient := *a.sharedHTTPClient()
+	return a.newClient(&httpClient)
+}
+
+// newClient returns a GitHub client using httpClient, pointed at the configured API and upload URLs.
+func (a *API) newClient(httpClient *http.Client) *github.Client {
 	client := github.NewClient(httpClient)
+	if a.APIURL != "" {
+		client.BaseURL, _ = config.ParseBaseURL("--api-url", a.APIURL)
+	}
+	if uploadURL := a.uploadURL(); uploadURL != "" {
+		client.UploadURL, _ = config.ParseBaseURL("--upload-url", uploadURL)
+	}
 	if a.TestURL != "" {
 		client.BaseURL, _ = url.Parse(a.TestURL)
 	}
 	return client
 }
 
-// NewAPI reads config data and conditionally prompts for the API token (as a password prompt).
+// checkOptions returns an error for the first invalid URL or pattern option. NewConfig() rejects them too, this is for
+// API values that didn't come from it.
+func (a *API) checkOptions() error {
+	if a.APIURL != "" {
+		if _, err := config.ParseBaseURL("--api-url", a.APIURL); err != nil {
+			return err
+		}
+	}
+	if a.UploadURL != "" {
+		if _, err := config.ParseBaseURL("--upload-url", a.UploadURL); err != nil {
+			return err
+		}
+	}
+	if err := config.CheckPatterns("--include", a.Includes); err != nil {
+		return err
+	}
+	return config.CheckPatterns("--exclude", a.Excludes)
+}
+
+// NewAPI reads config data and looks for the API token. Sources are tried in this order: --token, --token-file, the
+// GITHUB_TOKEN environment variable, git credential helpers (for the API host) and finally a password prompt.
 //
-// Always prompt for token if not specified. There are higher API limits for authenticated users.
+// Always prompt for token if not found elsewhere. There are higher API limits for authenticated users.
 //
 // :param config: Config struct value with options from the CLI.
 //
 // :param testTokenAnswer: For testing. Don't prompt for token, use this value instead.
 func NewAPI(config config.Config, testTokenAnswer string) (api API, err error) {
 	api = API{
-		NoComments: config.NoComments,
-		NoForks:    config.NoForks,
-		NoIssues:   config.NoIssues,
-		NoPrivate:  config.NoPrivate,
-		NoPublic:   config.NoPublic,
-		NoReleases: config.NoReleases,
-		NoWikis:    config.NoWikis,
-		Token:      config.Token,
-		User:       config.User,
-	}
-	if api.Token != "" {
+		APIURL:        config.APIURL,
+		UploadURL:     config.UploadURL,
+		Affiliation:   config.Affiliation,
+		CloneProtocol: config.CloneProtocol,
+		Excludes:      config.Excludes,
+		Includes:      config.Includes,
+		Languages:     config.Languages,
+		MaxSize:       config.MaxSize,
+		NoArchived:    config.NoArchived,
+		NoComments:    config.NoComments,
+		NoForks:       config.NoForks,
+		NoIssues:      config.NoIssues,
+		NoPrivate:     config.NoPrivate,
+		NoPublic:      config.NoPublic,
+		NoPulls:       config.NoPulls,
+		NoReleases:    config.NoReleases,
+		NoWikis:       config.NoWikis,
+		Orgs:          config.Orgs,
+		Retries:       config.Retries,
+		Token:         config.Token,
+		Topics:        config.Topics,
+		User:          config.User,
+	}
+	if config.Destination != "" && !config.DryRun { // Dry runs don't write to DESTINATION.
+		api.CacheDir = filepath.Join(config.Destination, StateDir, "cache")
+	}
+
+	if err = api.checkOptions(); err != nil {
+		return
+	}
+
+	// Non-interactive token sources.
+	if err = api.resolveToken(config.TokenFile); err != nil || api.Token != "" {
 		return
 	}
 
@@ -116,6 +362,9 @@ func NewAPI(config config.Config, testTokenAnswer string) (api API, err error) {
 			message = "GitHub personal access token (anonymous auth if blank): "
 		}
 		api.Token, err = prompt(message, testTokenAnswer)
+		if api.Token != "" {
+			api.TokenSource = TokenSourcePrompt
+		}
 	}
 
 	// Verify.
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"os"
 	"strings"
 	"testing"
 
@@ -10,6 +11,14 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
+func TestMain(m *testing.M) {
+	var code int
+	if err := testUtils.WithoutTokenSources(func() { code = m.Run() }); err != nil {
+		panic(err)
+	}
+	os.Exit(code)
+}
+
 func TestNewAPIWithToken(t *testing.T) {
 	assert := require.New(t)
 	api, err := NewAPI(config.Config{Token: "abc"}, "xyz")
@@ -107,3 +116,71 @@ func TestNewAPIError(t *testing.T) {
 		})
 	}
 }
+
+func TestNewAPIBadURL(t *testing.T) {
+	assert := require.New(t)
+
+	_, err := NewAPI(config.Config{Token: "abc", APIURL: "ghe.example.com"}, "")
+	assert.EqualError(err, "invalid --api-url, must be an http(s) URL: ghe.example.com")
+	_, err = NewAPI(config.Config{Token: "abc", UploadURL: "ftp://ghe.example.com/"}, "")
+	assert.EqualError(err, "invalid --upload-url, must be an http(s) URL: ftp://ghe.example.com/")
+
+	api, err := NewAPI(config.Config{Token: "abc", APIURL: "https://ghe.example.com/api/v3"}, "")
+	assert.NoError(err)
+	assert.Equal("https://ghe.example.com/api/v3", api.APIURL)
+}
+
+func TestAPI_getClientEnterprise(t *testing.T) {
+	assert := require.New(t)
+
+	client := (&API{}).getClient()
+	assert.Equal("https://api.github.com/", client.BaseURL.String())
+	assert.Equal("https://uploads.github.com/", client.UploadURL.String())
+
+	client = (&API{APIURL: "https://ghe.example.com/api/v3"}).getClient()
+	assert.Equal("https://ghe.example.com/api/v3/", client.BaseURL.String())
+	assert.Equal("https://ghe.example.com/api/uploads/", client.UploadURL.String())
+
+	client = (&API{APIURL: "https://ghe.example.com/api/v3/", UploadURL: "https://up.example.com"}).getClient()
+	assert.Equal("https://ghe.example.com/api/v3/", client.BaseURL.String())
+	assert.Equal("https://up.example.com/", client.UploadURL.String())
+}
+
+func TestAPI_cloneURL(t *testing.T) {
+	ghe := "https://ghe.example.com/api/v3/"
+	testCases := []struct {
+		apiURL   string
+		cloneURL string
+		expected string
+	}{
+		{"", "https://github.com/me/repo.git", "https://github.com/me/repo.git"},
+		{"https://api.github.com/", "https://github.com/me/repo.git", "https://github.com/me/repo.git"},
+		{ghe, "https://ghe.example.com/me/repo.git", "https://ghe.example.com/me/repo.git"},
+		{ghe, "https://internal:8443/me/repo.git", "https://ghe.example.com/me/repo.git"},
+		{"http://ghe.example.com:8080/api/v3/", "https://internal/me/repo.git", "http://ghe.example.com:8080/me/repo.git"},
+		{ghe, "git@internal:me/repo.git", "git@ghe.example.com:me/repo.git"},
+		{"https://ghe.example.com:8443/api/v3/", "git@internal:me/repo.git", "git@ghe.example.com:me/repo.git"},
+		{ghe, "ssh://git@internal:2222/me/repo.git", "ssh://git@ghe.example.com:2222/me/repo.git"},
+		{ghe, "/local/path", "/local/path"},
+		{"https://api.github.com/", "https://gist.github.com/abc123.git", "https://gist.github.com/abc123.git"},
+		{"https://api.github.com/", "git@gist.github.com:abc123.git", "git@gist.github.com:abc123.git"},
+		{ghe, "https://gist.internal/abc123.git", "https://gist.ghe.example.com/abc123.git"},
+		{ghe, "git@gist.internal:abc123.git", "git@gist.ghe.example.com:abc123.git"},
+		{ghe, "https://internal/gist/abc123.git", "https://ghe.example.com/gist/abc123.git"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.cloneURL, func(t *testing.T) {
+			assert := require.New(t)
+			assert.Equal(tc.expected, (&API{APIURL: tc.apiURL}).cloneURL(tc.cloneURL))
+		})
+	}
+}
+
+func TestSSHCloneURL(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Equal("git@gist.github.com:abc123.git", sshCloneURL("https://gist.github.com/abc123.git"))
+	assert.Equal("git@ghe.example.com:gist/abc123.git", sshCloneURL("https://ghe.example.com:8443/gist/abc123.git"))
+	assert.Equal("git@github.com:me/repo.git", sshCloneURL("git@github.com:me/repo.git"))
+	assert.Equal("/local/path", sshCloneURL("/local/path"))
+}
@@ -0,0 +1,182 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Robpol86/githubBackup/config"
+)
+
+// StateDir holds data about previous runs (e.g. the API cache), relative to the destination directory.
+const StateDir = ".githubBackup"
+
+// cacheMaxAge is how long cache entries are kept without being used, e.g. for repos that were deleted or filtered out.
+const cacheMaxAge = 30 * 24 * time.Hour
+
+// cacheEntry is one cached API response.
+type cacheEntry struct {
+	URL          string
+	ETag         string
+	LastModified string
+	Header       http.Header
+	Body         []byte
+}
+
+// cacheTransport is an http.RoundTripper that caches GET responses on disk and revalidates them with conditional
+// requests (If-None-Match/If-Modified-Since). GitHub doesn't count 304 Not Modified responses against the rate limit.
+type cacheTransport struct {
+	base http.RoundTripper
+	dir  string
+}
+
+// cachePath returns the cache file for a request. Responses differ per token and Accept header so they're part of the
+// key. Hashing also keeps tokens out of the file names.
+func (t *cacheTransport) cachePath(request *http.Request) string {
+	key := request.Header.Get("Authorization") + "\n" + request.Header.Get("Accept") + "\n" + request.URL.String()
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(t.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+func (t *cacheTransport) load(path string) *cacheEntry {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	entry := &cacheEntry{}
+	if err := json.Unmarshal(data, entry); err != nil {
+		return nil
+	}
+	return entry
+}
+
+// save atomically writes a cache entry. Failing to cache isn't fatal.
+func (t *cacheTransport) save(path string, entry *cacheEntry) {
+	log := config.GetLogger().WithField("url", entry.URL)
+	data, err := json.Marshal(entry)
+	if err == nil {
+		err = os.MkdirAll(t.dir, os.ModePerm)
+	}
+	var handle *os.File
+	if err == nil {
+		handle, err = ioutil.TempFile(t.dir, "tmp")
+	}
+	if err == nil {
+		_, err = handle.Write(data)
+		handle.Close()
+		if err == nil {
+			err = os.Rename(handle.Name(), path)
+		}
+		if err != nil {
+			os.Remove(handle.Name())
+		}
+	}
+	if err != nil {
+		log.Warnf("Failed to cache API response: %s", err.Error())
+	}
+}
+
+// pruneCache deletes cache entries (and leftover temporary files) that weren't used for cacheMaxAge. Entries are
+// touched whenever they're used. Failing to prune isn't fatal.
+func pruneCache(dir string) {
+	log := config.GetLogger().WithField("dir", dir)
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warnf("Failed to prune API cache: %s", err.Error())
+		}
+		return
+	}
+	pruned := 0
+	for _, file := range files {
+		if file.IsDir() || now().Sub(file.ModTime()) < cacheMaxAge {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, file.Name())); err != nil {
+			log.Warnf("Failed to prune API cache: %s", err.Error())
+			continue
+		}
+		pruned++
+	}
+	log.Debugf("Pruned %d unused API cache entries.", pruned)
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *cacheTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	// Only cache API listings, not downloads.
+	if request.Method != "GET" || strings.Contains(request.Header.Get("Accept"), "octet-stream") {
+		return t.base.RoundTrip(request)
+	}
+	path := t.cachePath(request)
+	entry := t.load(path)
+
+	// Make the request conditional. RoundTrippers must not modify the original request.
+	if entry != nil {
+		conditional := *request
+		conditional.Header = http.Header{}
+		for key, values := range request.Header {
+			conditional.Header[key] = values
+		}
+		if entry.ETag != "" {
+			conditional.Header.Set("If-None-Match", entry.ETag)
+		}
+		if entry.LastModified != "" {
+			conditional.Header.Set("If-Modified-Since", entry.LastModified)
+		}
+		request = &conditional
+	}
+
+	response, err := t.base.RoundTrip(request)
+	if err != nil {
+		return response, err
+	}
+
+	// Serve from cache. Keep the fresh rate limit headers.
+	if response.StatusCode == http.StatusNotModified && entry != nil {
+		config.GetLogger().WithField("url", entry.URL).Debug("API response not modified, using cache.")
+		response.Body.Close()
+		os.Chtimes(path, now(), now()) // Keep it from being pruned.
+		header := http.Header{}
+		for key, values := range entry.Header {
+			header[key] = values
+		}
+		for key, values := range response.Header {
+			if strings.HasPrefix(key, "X-Ratelimit-") {
+				header[key] = values
+			}
+		}
+		response.StatusCode = http.StatusOK
+		response.Status = "200 OK"
+		response.Header = header
+		response.Body = ioutil.NopCloser(bytes.NewReader(entry.Body))
+		response.ContentLength = int64(len(entry.Body))
+		return response, nil
+	}
+
+	// Cache new response.
+	etag, lastModified := response.Header.Get("ETag"), response.Header.Get("Last-Modified")
+	if response.StatusCode != http.StatusOK || (etag == "" && lastModified == "") {
+		return response, nil
+	}
+	body, err := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	response.Body = ioutil.NopCloser(bytes.NewReader(body))
+	t.save(path, &cacheEntry{
+		URL:          request.URL.String(),
+		ETag:         etag,
+		LastModified: lastModified,
+		Header:       response.Header,
+		Body:         body,
+	})
+	return response, nil
+}
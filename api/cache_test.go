@@ -0,0 +1,178 @@
+package api
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Robpol86/githubBackup/config"
+	"github.com/Robpol86/githubBackup/testUtils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPI_GetReposCache(t *testing.T) {
+	assert := require.New(t)
+	_, file, _, _ := runtime.Caller(0)
+	reply, err := ioutil.ReadFile(filepath.Join(filepath.Dir(file), "repos_test.json"))
+	assert.NoError(err)
+	tmpdir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpdir)
+	cacheDir := filepath.Join(tmpdir, "cache")
+
+	// HTTP response. Like GitHub only decrements the remaining rate limit for non-304 responses.
+	var conditional []string
+	remaining := 60
+	lastRemaining := ""
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-RateLimit-Limit", "60")
+		if r.URL.Path == "/user" { // Login lookup of authenticated runs. Not cached.
+			w.Header().Set("X-RateLimit-Remaining", lastRemaining)
+			w.Write([]byte(`{"login": "Robpol86"}`))
+			return
+		}
+		conditional = append(conditional, r.Header.Get("If-None-Match"))
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			lastRemaining = "42"
+			w.Header().Set("X-RateLimit-Remaining", lastRemaining)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		remaining--
+		lastRemaining = strconv.Itoa(remaining)
+		w.Header().Set("X-RateLimit-Remaining", lastRemaining)
+		w.Header().Set("ETag", `"v1"`)
+		w.Write(reply)
+	}))
+	defer ts.Close()
+
+	run := func(token string) (GitHubRepos, *API) {
+		ghRepos := GitHubRepos{}
+		api := &API{TestURL: ts.URL, CacheDir: cacheDir, Token: token}
+		_, _, err := testUtils.WithCapSys(func() {
+			assert.NoError(api.GetRepos(&ghRepos))
+		})
+		assert.NoError(err)
+		return ghRepos, api
+	}
+
+	// First run fills the cache.
+	first, api := run("abc")
+	assert.Len(first, 3)
+	assert.Equal([]string{""}, conditional)
+	assert.Equal(59, api.Remaining)
+	files, err := ioutil.ReadDir(cacheDir)
+	assert.NoError(err)
+	assert.Len(files, 1)
+
+	// Second run revalidates.
+	second, api := run("abc")
+	assert.Equal(first, second)
+	assert.Equal([]string{"", `"v1"`}, conditional)
+	assert.Equal(42, api.Remaining)
+
+	// Different token doesn't share cached responses.
+	third, _ := run("xyz")
+	assert.Equal(first, third)
+	assert.Equal([]string{"", `"v1"`, ""}, conditional)
+	files, err = ioutil.ReadDir(cacheDir)
+	assert.NoError(err)
+	assert.Len(files, 2)
+
+	// Disabled.
+	_, _, err = testUtils.WithCapSys(func() {
+		api := &API{TestURL: ts.URL, Token: "abc"}
+		assert.NoError(api.GetRepos(&GitHubRepos{}))
+	})
+	assert.NoError(err)
+	assert.Equal([]string{"", `"v1"`, "", ""}, conditional)
+}
+
+func TestNewAPICacheDir(t *testing.T) {
+	assert := require.New(t)
+
+	api, err := NewAPI(config.Config{Token: "abc"}, "")
+	assert.NoError(err)
+	assert.Equal("", api.CacheDir)
+
+	api, err = NewAPI(config.Config{Token: "abc", Destination: "dest"}, "")
+	assert.NoError(err)
+	assert.Equal(filepath.Join("dest", ".githubBackup", "cache"), api.CacheDir)
+
+	api, err = NewAPI(config.Config{Token: "abc", Destination: "dest", DryRun: true}, "")
+	assert.NoError(err)
+	assert.Equal("", api.CacheDir)
+}
+
+func TestAPI_GetReposCachePrune(t *testing.T) {
+	assert := require.New(t)
+	tmpdir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpdir)
+	cacheDir := filepath.Join(tmpdir, "cache")
+	assert.NoError(os.Mkdir(cacheDir, os.ModePerm))
+
+	// Entries from previous runs.
+	age := func(name string) time.Duration {
+		info, err := os.Stat(filepath.Join(cacheDir, name))
+		assert.NoError(err)
+		return time.Since(info.ModTime())
+	}
+	setAge := func(name string, age time.Duration) {
+		path := filepath.Join(cacheDir, name)
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			assert.NoError(ioutil.WriteFile(path, []byte("{}"), 0644))
+		}
+		assert.NoError(os.Chtimes(path, time.Now().Add(-age), time.Now().Add(-age)))
+	}
+	setAge("old.json", cacheMaxAge+time.Hour)
+	setAge("tmp123", cacheMaxAge+time.Hour)
+	setAge("recent.json", cacheMaxAge-time.Hour)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("[]"))
+	}))
+	defer ts.Close()
+	run := func() {
+		_, _, _, err := testUtils.WithLogging(func() {
+			api := &API{TestURL: ts.URL, CacheDir: cacheDir, User: "me"}
+			assert.NoError(api.GetRepos(&GitHubRepos{}))
+		})
+		assert.NoError(err)
+	}
+
+	// Unused entries are pruned.
+	run()
+	files, err := ioutil.ReadDir(cacheDir)
+	assert.NoError(err)
+	var names []string
+	for _, file := range files {
+		names = append(names, file.Name())
+	}
+	assert.Len(names, 2)
+	assert.Contains(names, "recent.json")
+	assert.NotContains(names, "old.json")
+	assert.NotContains(names, "tmp123")
+
+	// Used entries are kept.
+	var entry string
+	for _, name := range names {
+		if name != "recent.json" {
+			entry = name
+		}
+	}
+	setAge(entry, cacheMaxAge-time.Hour)
+	run()
+	assert.True(age(entry) < time.Minute)
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"path"
+	"regexp"
+
+	"github.com/Robpol86/githubBackup/config"
+)
+
+// Reasons repos are skipped by --no-archived, --topic, --language and --max-size. Keys of API.SkippedRepos.
+const (
+	SkipArchived = "archived"
+	SkipTopic    = "without topic"
+	SkipLanguage = "other language"
+	SkipSize     = "too large"
+)
+
+// SkipReasons lists the Skip* constants in the order filters are applied.
+var SkipReasons = []string{SkipArchived, SkipTopic, SkipLanguage, SkipSize}
+
+// matchPatterns returns the first pattern matching any of the names, or an empty string if none match. Invalid
+// patterns never match (NewConfig() and NewAPI() reject them).
+func matchPatterns(patterns []string, names ...string) string {
+	for _, pattern := range patterns {
+		for _, name := range names {
+			var matched bool
+			if config.IsRegexp(pattern) {
+				matched, _ = regexp.MatchString(pattern[1:len(pattern)-1], name)
+			} else {
+				matched, _ = path.Match(pattern, name)
+			}
+			if matched {
+				return pattern
+			}
+		}
+	}
+	return ""
+}
+
+// skipReason returns why a repo or gist is skipped by --include/--exclude, or an empty string if it isn't.
+//
+// :param names: Names to match patterns against, e.g. the repo name and OWNER/NAME.
+func (a *API) skipReason(names ...string) string {
+	if len(a.Includes) > 0 && matchPatterns(a.Includes, names...) == "" {
+		return "doesn't match --include"
+	}
+	if pattern := matchPatterns(a.Excludes, names...); pattern != "" {
+		return "matches --exclude " + pattern
+	}
+	return ""
+}
+
+// skipped counts a repo left out by --no-archived, --topic, --language or --max-size.
+func (a *API) skipped(reason string) {
+	apiMu.Lock()
+	defer apiMu.Unlock()
+	if a.SkippedRepos == nil {
+		a.SkippedRepos = make(map[string]int)
+	}
+	a.SkippedRepos[reason]++
+}
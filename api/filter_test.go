@@ -0,0 +1,183 @@
+package api
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/Robpol86/githubBackup/config"
+	"github.com/Robpol86/githubBackup/testUtils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewAPIBadPattern(t *testing.T) {
+	assert := require.New(t)
+
+	_, err := NewAPI(config.Config{Token: "abc", Excludes: []string{"/[/"}}, "")
+	assert.EqualError(err, "invalid --exclude pattern /[/: error parsing regexp: missing closing ]: `[`")
+}
+
+func TestMatchPatterns(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Equal("", matchPatterns(nil, "name"))
+	assert.Equal("go-*", matchPatterns([]string{"py*", "go-*"}, "go-github"))
+	assert.Equal("", matchPatterns([]string{"go-*"}, "me/go-github"))
+	assert.Equal("me/*", matchPatterns([]string{"me/*"}, "go-github", "me/go-github"))
+	assert.Equal("/hub$/", matchPatterns([]string{"/hub$/"}, "go-github"))
+	assert.Equal("", matchPatterns([]string{"/^hub/"}, "go-github"))
+	assert.Equal("", matchPatterns([]string{"[a-"}, "a"))
+}
+
+func TestAPI_GetReposPatterns(t *testing.T) {
+	_, file, _, _ := runtime.Caller(0)
+	reply, err := ioutil.ReadFile(filepath.Join(filepath.Dir(file), "repos_test.json"))
+	require.NoError(t, err)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write(reply)
+	}))
+	defer ts.Close()
+
+	testCases := []struct {
+		name     string
+		includes []string
+		excludes []string
+		expected []string
+		message  string
+	}{
+		{"none", nil, nil, []string{"appveyor-artifacts", "click*", "Documents"}, ""},
+		{"include", []string{"/^[a-c]/"}, nil, []string{"appveyor-artifacts", "click*"},
+			"Skipping filtered repo: Documents (doesn't match --include)"},
+		{"include full name", []string{"Robpol86/Doc*"}, nil, []string{"Documents"}, ""},
+		{"exclude", nil, []string{"Documents", "/^app/"}, []string{"click*"},
+			"Skipping filtered repo: Documents (matches --exclude Documents)"},
+		{"both", []string{"*"}, []string{"click\\*"}, []string{"appveyor-artifacts", "Documents"},
+			"Skipping filtered repo: click* (matches --exclude click\\*)"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert := require.New(t)
+			ghRepos := GitHubRepos{}
+			logs, _, _, err := testUtils.WithLogging(func() {
+				api := &API{TestURL: ts.URL, Includes: tc.includes, Excludes: tc.excludes}
+				assert.NoError(api.GetRepos(&ghRepos))
+			})
+			assert.NoError(err)
+
+			var names []string
+			for _, repo := range ghRepos {
+				names = append(names, repo.Name)
+			}
+			assert.Equal(tc.expected, names)
+			if tc.message != "" {
+				var messages []string
+				for _, entry := range logs.Entries {
+					messages = append(messages, entry.Message)
+				}
+				assert.Contains(messages, tc.message)
+			}
+		})
+	}
+}
+
+func TestAPI_GetGistsPatterns(t *testing.T) {
+	assert := require.New(t)
+	_, file, _, _ := runtime.Caller(0)
+	reply, err := ioutil.ReadFile(filepath.Join(filepath.Dir(file), "gists_test.json"))
+	assert.NoError(err)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write(reply)
+	}))
+	defer ts.Close()
+
+	ghGists := GitHubGists{}
+	logs, _, _, err := testUtils.WithLogging(func() {
+		api := &API{TestURL: ts.URL, Includes: []string{"/^[0-8]/"}, Excludes: []string{"4b782fe5*"}}
+		assert.NoError(api.GetGists(&ghGists))
+	})
+	assert.NoError(err)
+
+	var ids []string
+	for _, gist := range ghGists {
+		ids = append(ids, gist.ID)
+	}
+	assert.Equal([]string{"0d27d3ae9de1df0e6944186cf849fdc5", "8d5e0a221c7fa8783f1a91fe98637cd3",
+		"6d0e2814ee26e04f2c1210849b30040d"}, ids)
+	var messages []string
+	for _, entry := range logs.Entries {
+		messages = append(messages, entry.Message)
+	}
+	assert.Contains(messages, "Skipping filtered gist: 4b782fe5770ac56797b395393b31683d (matches --exclude 4b782fe5*)")
+	assert.Contains(messages, "Skipping filtered gist: 93804161c92d8e9b5980 (doesn't match --include)")
+}
+
+func TestAPI_GetReposMetadataFilters(t *testing.T) {
+	repo := `{"name": "%s", "owner": {"login": "me"}, "size": %d, "fork": false, "private": false,
+		"pushed_at": "2017-01-01T00:00:00Z", "updated_at": "2017-01-01T00:00:00Z",
+		"clone_url": "https://github.com/me/%[1]s.git", "has_issues": false, "has_wiki": false,
+		"archived": %[3]t, "topics": %[4]s, "language": %[5]s}`
+	reply := "[" + strings.Join([]string{
+		fmt.Sprintf(repo, "old", 10, true, `["cli"]`, `"Go"`),
+		fmt.Sprintf(repo, "big", 5000, false, `["cli", "backup"]`, `"Python"`),
+		fmt.Sprintf(repo, "tool", 20, false, `["CLI"]`, `"go"`),
+		fmt.Sprintf(repo, "notes", 1, false, `[]`, "null"),
+	}, ",") + "]"
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, mediaTypeTopicsPreview, r.Header.Get("Accept"))
+		w.Write([]byte(reply))
+	}))
+	defer ts.Close()
+
+	testCases := []struct {
+		name     string
+		api      API
+		expected []string
+		skipped  map[string]int
+	}{
+		{"none", API{}, []string{"old", "big", "tool", "notes"}, nil},
+		{"archived", API{NoArchived: true}, []string{"big", "tool", "notes"}, map[string]int{SkipArchived: 1}},
+		{"topic", API{Topics: []string{"cli"}}, []string{"old", "big", "tool"}, map[string]int{SkipTopic: 1}},
+		{"language", API{Languages: []string{"GO"}}, []string{"old", "tool"}, map[string]int{SkipLanguage: 2}},
+		{"size", API{MaxSize: 1000}, []string{"old", "tool", "notes"}, map[string]int{SkipSize: 1}},
+		{"all", API{NoArchived: true, Topics: []string{"cli"}, MaxSize: 1000}, []string{"tool"},
+			map[string]int{SkipArchived: 1, SkipTopic: 1, SkipSize: 1}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert := require.New(t)
+			ghRepos := GitHubRepos{}
+			logs, _, _, err := testUtils.WithLogging(func() {
+				tc.api.TestURL = ts.URL
+				assert.NoError(tc.api.GetRepos(&ghRepos))
+			})
+			assert.NoError(err)
+
+			var names []string
+			for _, repo := range ghRepos {
+				names = append(names, repo.Name)
+			}
+			assert.Equal(tc.expected, names)
+			assert.Equal(tc.skipped, tc.api.SkippedRepos)
+			if tc.name == "size" {
+				assert.Equal("Skipping filtered repo: big (too large)", logs.Entries[1].Message)
+			}
+			if tc.name == "none" {
+				assert.Equal(GitHubRepo{Name: "old", Owner: "me", Size: 10, PushedAt: ghRepos[0].PushedAt,
+					UpdatedAt: ghRepos[0].UpdatedAt, CloneURL: "https://github.com/me/old.git", Archived: true,
+					Topics: []string{"cli"}, Language: "Go"}, ghRepos[0])
+				assert.Equal("", ghRepos[3].Language)
+				assert.Equal(1, ghRepos.Counts()["archived"])
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"path/filepath"
+
+	"github.com/Robpol86/githubBackup/config"
+	"github.com/google/go-github/github"
+)
+
+// GistCommentsFile is the name of the file (in each gist's backup directory) that comments are written to.
+const GistCommentsFile = "comments.json"
+
+// GetGistComments retrieves the comment thread of a gist.
+//
+// :param ghGist: Query comments of this gist.
+func (a *API) GetGistComments(ghGist *GitHubGist) ([]*github.GistComment, error) {
+	log := config.GetLogger().WithField("gist", ghGist.ID)
+	client := a.getClient()
+
+	comments := []*github.GistComment{}
+	options := github.ListOptions{PerPage: 100}
+	for {
+		page, response, err := client.Gists.ListComments(ghGist.ID, &options)
+		logWithFields := log.WithField("page", options.Page).WithField("numComments", len(page))
+		logWithFields.WithField("response", response).Debug("Got response from GitHub gist comments API.")
+		if err != nil {
+			err = translateError(err)
+			logWithFields.WithField("error", err.Error()).Debug("Failed to query for gist comments.")
+			return nil, err
+		}
+		a.noteRate(response)
+		comments = append(comments, page...)
+		if response.NextPage == 0 {
+			break
+		}
+		options.Page = response.NextPage
+	}
+
+	return comments, nil
+}
+
+// ExportGistComments writes the comment thread of a gist to a JSON file.
+//
+// :param ghGist: Export comments of this gist.
+//
+// :param dir: Directory to write the JSON file into.
+func (a *API) ExportGistComments(ghGist *GitHubGist, dir string) error {
+	comments, err := a.GetGistComments(ghGist)
+	if err != nil {
+		return err
+	}
+	return writeJSON(filepath.Join(dir, GistCommentsFile), comments)
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Robpol86/githubBackup/testUtils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPI_ExportGistComments(t *testing.T) {
+	assert := require.New(t)
+
+	// HTTP response.
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal("/gists/93804161c92d8e9b5980/comments", r.URL.Path)
+		w.Write([]byte(`[{"id": 1, "body": "Thanks!", "user": {"login": "someone"}}]`))
+	}))
+	defer ts.Close()
+
+	tmpdir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpdir)
+
+	// Run.
+	_, stdout, stderr, err := testUtils.WithLogging(func() {
+		api := &API{TestURL: ts.URL}
+		assert.NoError(api.ExportGistComments(&GitHubGist{ID: "93804161c92d8e9b5980"}, tmpdir))
+	})
+	assert.NoError(err)
+	assert.Empty(stdout)
+	assert.Empty(stderr)
+
+	// Verify.
+	contents, err := ioutil.ReadFile(filepath.Join(tmpdir, GistCommentsFile))
+	assert.NoError(err)
+	var decoded []map[string]interface{}
+	assert.NoError(json.Unmarshal(contents, &decoded))
+	assert.Len(decoded, 1)
+	assert.Equal("Thanks!", decoded[0]["body"])
+}
+
+func TestAPI_ExportGistCommentsBad(t *testing.T) {
+	assert := require.New(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(404)
+		w.Write([]byte(`{"message": "Not Found", "documentation_url": ""}`))
+	}))
+	defer ts.Close()
+
+	tmpdir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpdir)
+
+	logs, _, _, err := testUtils.WithLogging(func() {
+		api := &API{TestURL: ts.URL}
+		err := api.ExportGistComments(&GitHubGist{ID: "dne", Name: "file.txt"}, tmpdir)
+		assert.EqualError(err, "GET "+ts.URL+"/gists/dne/comments?per_page=100: 404 Not Found []")
+	})
+	assert.NoError(err)
+	assert.Equal("Failed to query for gist comments.", logs.LastEntry().Message)
+	assert.Equal("dne", logs.LastEntry().Data["gist"])
+	_, err = os.Stat(filepath.Join(tmpdir, GistCommentsFile))
+	assert.True(os.IsNotExist(err))
+}
@@ -1,7 +1,7 @@
 package api
 
 import (
-	"errors"
+	"regexp"
 	"sort"
 	"strings"
 	"time"
@@ -10,9 +10,15 @@ import (
 	"github.com/google/go-github/github"
 )
 
+const maxSlugLen = 50
+
+var reSlug = regexp.MustCompile(`[^a-z0-9]+`)
+
 // GitHubGist holds data for one GitHub Gist.
 type GitHubGist struct {
-	Name        string
+	ID          string
+	Name        string // First file name (alphabetically). Not unique.
+	Slug        string // Readable version of the description (or Name if there's no description).
 	Size        int
 	Private     bool
 	PushedAt    time.Time
@@ -20,6 +26,23 @@ type GitHubGist struct {
 	HasComments bool
 }
 
+// DirName returns a stable and unique directory name for the gist (its ID) followed by its slug for readability.
+func (g *GitHubGist) DirName() string {
+	if g.Slug == "" {
+		return g.ID
+	}
+	return g.ID + "-" + g.Slug
+}
+
+// slugify converts text into a short lowercase string only containing letters, numbers and dashes.
+func slugify(text string) string {
+	slug := strings.Trim(reSlug.ReplaceAllString(strings.ToLower(text), "-"), "-")
+	if len(slug) > maxSlugLen {
+		slug = strings.TrimRight(slug[:maxSlugLen], "-")
+	}
+	return slug
+}
+
 // GitHubGists is a slice of GitHubGist with attached convenience function receivers.
 type GitHubGists []GitHubGist
 
@@ -47,6 +70,7 @@ func (g *GitHubGists) Counts() map[string]int {
 
 func (a *API) parseGist(gist *github.Gist, name string, size int, ghGists *GitHubGists) {
 	ghGist := GitHubGist{
+		ID:          *gist.ID,
 		Name:        name,
 		Size:        size,
 		Private:     !*gist.Public,
@@ -54,6 +78,17 @@ func (a *API) parseGist(gist *github.Gist, name string, size int, ghGists *GitHu
 		CloneURL:    *gist.GitPullURL,
 		HasComments: *gist.Comments > 0,
 	}
+	if a.CloneProtocol == "ssh" {
+		ghGist.CloneURL = sshCloneURL(ghGist.CloneURL)
+	}
+	ghGist.CloneURL = a.cloneURL(ghGist.CloneURL)
+
+	// Human readable part of the directory name.
+	if gist.Description != nil && *gist.Description != "" {
+		ghGist.Slug = slugify(*gist.Description)
+	} else {
+		ghGist.Slug = slugify(name)
+	}
 
 	// Override if no comments desired.
 	if a.NoComments {
@@ -80,17 +115,13 @@ func (a *API) GetGists(ghGists *GitHubGists) error {
 		logWithFields := log.WithField("page", options.ListOptions.Page).WithField("numGists", len(gists))
 		logWithFields.WithField("response", response).Debug("Got response from GitHub gists API.")
 		if err != nil {
-			if strings.HasPrefix(err.Error(), "invalid character ") {
-				err = errors.New("invalid JSON response from server")
-			}
+			err = translateError(err)
 			logWithFields.WithField("error", err.Error()).Debug("Failed to query for gists.")
 			return err
 		}
 
 		// Note rate limiting.
-		a.Limit = response.Limit
-		a.Remaining = response.Remaining
-		a.Reset = response.Reset
+		a.noteRate(response)
 
 		// Parse.
 		for _, gist := range gists {
@@ -103,7 +134,9 @@ func (a *API) GetGists(ghGists *GitHubGists) error {
 			sort.Strings(fileNames)
 			name := fileNames[0]
 
-			if a.NoPublic && *gist.Public {
+			if reason := a.skipReason(*gist.ID); reason != "" {
+				logWithFields.Debugf("Skipping filtered gist: %s (%s)", *gist.ID, reason)
+			} else if a.NoPublic && *gist.Public {
 				logWithFields.Debugf("Skipping public gist: %s", name)
 			} else if a.NoPrivate && !*gist.Public {
 				logWithFields.Debugf("Skipping secret gist: %s", name)
@@ -171,3 +171,95 @@ func TestAPI_GetGistsPagination(t *testing.T) {
 	sort.Strings(actual)
 	assert.Equal(expected, actual)
 }
+
+func TestSlugify(t *testing.T) {
+	assert := require.New(t)
+	assert.Equal("ffmpeg-time-lapse", slugify("ffmpeg time-lapse"))
+	assert.Equal("gistfile1-txt", slugify("gistfile1.txt"))
+	assert.Equal("gopro-hero3-black-edition-linux-kernel-failure", slugify("GoPro Hero3 Black Edition Linux Kernel Failure"))
+	assert.Equal("", slugify("!!!"))
+	assert.Equal(strings.Repeat("a", 49), slugify(strings.Repeat("a", 49)+" "+strings.Repeat("b", 10)))
+}
+
+func TestGitHubGist_DirName(t *testing.T) {
+	assert := require.New(t)
+	assert.Equal("93804161c92d8e9b5980-gopro", (&GitHubGist{ID: "93804161c92d8e9b5980", Slug: "gopro"}).DirName())
+	assert.Equal("93804161c92d8e9b5980", (&GitHubGist{ID: "93804161c92d8e9b5980"}).DirName())
+}
+
+func TestAPI_GetGistsUnique(t *testing.T) {
+	assert := require.New(t)
+	_, file, _, _ := runtime.Caller(0)
+	reply, err := ioutil.ReadFile(filepath.Join(filepath.Dir(file), "gists_test.json"))
+	assert.NoError(err)
+
+	// HTTP response.
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write(reply)
+	}))
+	defer ts.Close()
+
+	// Run.
+	ghGists := GitHubGists{}
+	_, _, err = testUtils.WithCapSys(func() {
+		api := &API{TestURL: ts.URL}
+		assert.NoError(api.GetGists(&ghGists))
+	})
+	assert.NoError(err)
+
+	// Verify.
+	dirNames := map[string]bool{}
+	for _, gist := range ghGists {
+		dirNames[gist.DirName()] = true
+	}
+	assert.Len(dirNames, len(ghGists))
+	assert.True(dirNames["0d27d3ae9de1df0e6944186cf849fdc5-ffmpeg-time-lapse"])
+}
+
+func TestAPI_GetGistsCloneProtocol(t *testing.T) {
+	assert := require.New(t)
+	_, file, _, _ := runtime.Caller(0)
+	reply, err := ioutil.ReadFile(filepath.Join(filepath.Dir(file), "gists_test.json"))
+	assert.NoError(err)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write(reply)
+	}))
+	defer ts.Close()
+
+	ghGists := GitHubGists{}
+	_, _, _, err = testUtils.WithLogging(func() {
+		api := &API{TestURL: ts.URL, CloneProtocol: "ssh"}
+		assert.NoError(api.GetGists(&ghGists))
+	})
+	assert.NoError(err)
+	assert.Equal("git@gist.github.com:0d27d3ae9de1df0e6944186cf849fdc5.git", ghGists[0].CloneURL)
+}
+
+func TestAPI_GetGistsAPIURL(t *testing.T) {
+	for _, protocol := range []string{"https", "ssh"} {
+		t.Run(protocol, func(t *testing.T) {
+			assert := require.New(t)
+			_, file, _, _ := runtime.Caller(0)
+			reply, err := ioutil.ReadFile(filepath.Join(filepath.Dir(file), "gists_test.json"))
+			assert.NoError(err)
+
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.Write(reply)
+			}))
+			defer ts.Close()
+
+			ghGists := GitHubGists{}
+			_, _, _, err = testUtils.WithLogging(func() {
+				api := &API{TestURL: ts.URL, APIURL: "https://api.github.com/", CloneProtocol: protocol}
+				assert.NoError(api.GetGists(&ghGists))
+			})
+			assert.NoError(err)
+			if protocol == "ssh" {
+				assert.Equal("git@gist.github.com:0d27d3ae9de1df0e6944186cf849fdc5.git", ghGists[0].CloneURL)
+			} else {
+				assert.Equal("https://gist.github.com/0d27d3ae9de1df0e6944186cf849fdc5.git", ghGists[0].CloneURL)
+			}
+		})
+	}
+}
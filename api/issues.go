@@ -0,0 +1,195 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	"github.com/Robpol86/githubBackup/config"
+	"github.com/google/go-github/github"
+)
+
+// IssuesFile is the name of the file (in each repo's backup directory) that issues are written to.
+const IssuesFile = "issues.json"
+
+// GitHubIssue holds one GitHub issue (including labels, milestone, assignees and timestamps) and all of its comments.
+type GitHubIssue struct {
+	Issue    *github.Issue          `json:"issue"`
+	Comments []*github.IssueComment `json:"comments"`
+}
+
+// GetIssues retrieves all open and closed issues of a repository along with their comments. Comments are queried for
+// the whole repository at once instead of once per issue to save API calls.
+//
+// :param ghRepo: Query issues of this repo.
+func (a *API) GetIssues(ghRepo *GitHubRepo) ([]GitHubIssue, error) {
+	issues, _, err := a.updateIssues(ghRepo, nil)
+	return issues, err
+}
+
+// lastUpdated returns the latest update time of the issues and of the comments in a previous export. Zero if none.
+func lastUpdated(issues []GitHubIssue) (issuesSince, commentsSince time.Time) {
+	for _, ghIssue := range issues {
+		if ghIssue.Issue.UpdatedAt != nil && ghIssue.Issue.UpdatedAt.After(issuesSince) {
+			issuesSince = *ghIssue.Issue.UpdatedAt
+		}
+		for _, comment := range ghIssue.Comments {
+			if comment.UpdatedAt != nil && comment.UpdatedAt.After(commentsSince) {
+				commentsSince = *comment.UpdatedAt
+			}
+		}
+	}
+	return
+}
+
+// sameTime returns true if both timestamps are equal or both are missing.
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+// updateIssues retrieves the issues and comments of a repository that were created or updated since the last update
+// in previous and merges them into it. Everything is retrieved if previous is empty. Deleted issues and comments are
+// kept.
+//
+// :param ghRepo: Query issues of this repo.
+//
+// :param previous: Issues from the last export, left as-is.
+func (a *API) updateIssues(ghRepo *GitHubRepo, previous []GitHubIssue) ([]GitHubIssue, bool, error) {
+	log := config.GetLogger().WithField("repo", ghRepo.Name)
+	client := a.getClient()
+	changed := false
+
+	issues := make([]GitHubIssue, len(previous))
+	byURL := map[string]int{}
+	for i, ghIssue := range previous {
+		issues[i] = GitHubIssue{Issue: ghIssue.Issue, Comments: append([]*github.IssueComment{}, ghIssue.Comments...)}
+		if ghIssue.Issue.URL != nil {
+			byURL[*ghIssue.Issue.URL] = i
+		}
+	}
+	issuesSince, commentsSince := lastUpdated(previous)
+
+	// Query issues. Since includes issues updated at that exact time, those are already in previous.
+	options := github.IssueListByRepoOptions{State: "all", Direction: "asc", Since: issuesSince}
+	options.PerPage = 100
+	for {
+		page, response, err := client.Issues.ListByRepo(ghRepo.Owner, ghRepo.Name, &options)
+		logWithFields := log.WithField("page", options.ListOptions.Page).WithField("numIssues", len(page))
+		logWithFields.WithField("response", response).Debug("Got response from GitHub issues API.")
+		if err != nil {
+			err = translateError(err)
+			logWithFields.WithField("error", err.Error()).Debug("Failed to query for issues.")
+			return nil, false, err
+		}
+		a.noteRate(response)
+		for _, issue := range page {
+			if i, ok := byURL[*issue.URL]; ok {
+				changed = changed || !sameTime(issues[i].Issue.UpdatedAt, issue.UpdatedAt)
+				issues[i].Issue = issue
+				continue
+			}
+			changed = true
+			byURL[*issue.URL] = len(issues)
+			issues = append(issues, GitHubIssue{Issue: issue, Comments: []*github.IssueComment{}})
+		}
+		if response.NextPage == 0 {
+			break
+		}
+		options.ListOptions.Page = response.NextPage
+	}
+
+	// Query comments.
+	commentOptions := github.IssueListCommentsOptions{Sort: "created", Direction: "asc", Since: commentsSince}
+	commentOptions.PerPage = 100
+	for {
+		page, response, err := client.Issues.ListComments(ghRepo.Owner, ghRepo.Name, 0, &commentOptions)
+		logWithFields := log.WithField("page", commentOptions.ListOptions.Page).WithField("numComments", len(page))
+		logWithFields.WithField("response", response).Debug("Got response from GitHub issue comments API.")
+		if err != nil {
+			err = translateError(err)
+			logWithFields.WithField("error", err.Error()).Debug("Failed to query for issue comments.")
+			return nil, false, err
+		}
+		a.noteRate(response)
+		for _, comment := range page {
+			if comment.IssueURL == nil {
+				continue
+			}
+			i, ok := byURL[*comment.IssueURL]
+			if !ok {
+				logWithFields.Debugf("Skipping comment on unknown issue: %s", *comment.IssueURL)
+				continue
+			}
+			if mergeComment(&issues[i], comment) {
+				changed = true
+			}
+		}
+		if response.NextPage == 0 {
+			break
+		}
+		commentOptions.ListOptions.Page = response.NextPage
+	}
+
+	return issues, changed, nil
+}
+
+// mergeComment replaces the comment with the same ID in an issue or appends it. Returns false if it's unchanged.
+func mergeComment(ghIssue *GitHubIssue, comment *github.IssueComment) bool {
+	for j, existing := range ghIssue.Comments {
+		if existing.ID != nil && comment.ID != nil && *existing.ID == *comment.ID {
+			ghIssue.Comments[j] = comment
+			return !sameTime(existing.UpdatedAt, comment.UpdatedAt)
+		}
+	}
+	ghIssue.Comments = append(ghIssue.Comments, comment)
+	return true
+}
+
+// readIssues reads a previous export of issues. Returns nil if there's none or it's unreadable.
+func readIssues(path string) []GitHubIssue {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var issues []GitHubIssue
+	if err = json.Unmarshal(data, &issues); err != nil {
+		return nil
+	}
+	for _, ghIssue := range issues {
+		if ghIssue.Issue == nil {
+			return nil
+		}
+	}
+	return issues
+}
+
+// ExportIssues writes all issues and their comments of a repository to a JSON file. If the repo's issues were already
+// backed up only new and updated issues and comments are queried and merged into the file, which isn't written again
+// if nothing changed.
+//
+// :param ghRepo: Export issues of this repo.
+//
+// :param dir: Directory to write the JSON file into.
+func (a *API) ExportIssues(ghRepo *GitHubRepo, dir string) (skipped bool, err error) {
+	log := config.GetLogger().WithField("repo", ghRepo.Name)
+	path := filepath.Join(dir, IssuesFile)
+	previous := readIssues(path)
+
+	issues, changed, err := a.updateIssues(ghRepo, previous)
+	if err != nil {
+		return
+	}
+	if previous != nil && !changed {
+		log.WithField("path", path).Debug("Issues unchanged since the last backup, skipping.")
+		return true, nil
+	}
+	if err = writeJSON(path, issues); err != nil {
+		return
+	}
+	log.WithField("path", path).Debugf("Wrote %d issues.", len(issues))
+	return
+}
@@ -0,0 +1,213 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/Robpol86/githubBackup/testUtils"
+	"github.com/stretchr/testify/require"
+)
+
+const issueCommentsReply = `[
+  {"id": 1, "body": "First.", "issue_url": "https://api.github.com/repos/Robpol86/githubBackup/issues/2"},
+  {"id": 2, "body": "Deleted issue.", "issue_url": "https://api.github.com/repos/Robpol86/githubBackup/issues/9"},
+  {"id": 3, "body": "Second.", "issue_url": "https://api.github.com/repos/Robpol86/githubBackup/issues/2"}
+]`
+
+func issuesServer(assert *require.Assertions, failOn string) *httptest.Server {
+	_, file, _, _ := runtime.Caller(0)
+	reply, err := ioutil.ReadFile(filepath.Join(filepath.Dir(file), "issues_test.json"))
+	assert.NoError(err)
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/Robpol86/githubBackup/issues":
+			assert.Equal("all", r.URL.Query().Get("state"))
+			if failOn == "issues" {
+				w.Write([]byte("{':"))
+			} else {
+				w.Write(reply)
+			}
+		case "/repos/Robpol86/githubBackup/issues/comments":
+			if failOn == "comments" {
+				w.Write([]byte("{':"))
+			} else {
+				w.Write([]byte(issueCommentsReply))
+			}
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+}
+
+func TestAPI_GetIssues(t *testing.T) {
+	assert := require.New(t)
+	ts := issuesServer(assert, "")
+	defer ts.Close()
+
+	// Run.
+	var issues []GitHubIssue
+	_, stdout, stderr, err := testUtils.WithLogging(func() {
+		var err error
+		api := &API{TestURL: ts.URL}
+		issues, err = api.GetIssues(&GitHubRepo{Name: "githubBackup", Owner: "Robpol86"})
+		assert.NoError(err)
+	})
+	assert.NoError(err)
+	assert.Empty(stdout)
+	assert.Empty(stderr)
+
+	// Verify.
+	assert.Len(issues, 2)
+	assert.Equal(2, *issues[0].Issue.Number)
+	assert.Equal("enhancement", *issues[0].Issue.Labels[0].Name)
+	assert.Equal("v0.1.0", *issues[0].Issue.Milestone.Title)
+	assert.Equal("Robpol86", *issues[0].Issue.Assignees[0].Login)
+	assert.Len(issues[0].Comments, 2)
+	assert.Equal("First.", *issues[0].Comments[0].Body)
+	assert.Equal("Second.", *issues[0].Comments[1].Body)
+	assert.Equal(1, *issues[1].Issue.Number)
+	assert.Len(issues[1].Comments, 0)
+}
+
+func TestAPI_GetIssuesBad(t *testing.T) {
+	for _, failOn := range []string{"issues", "comments"} {
+		t.Run(failOn, func(t *testing.T) {
+			assert := require.New(t)
+			ts := issuesServer(assert, failOn)
+			defer ts.Close()
+
+			logs, _, _, err := testUtils.WithLogging(func() {
+				api := &API{TestURL: ts.URL}
+				_, err := api.GetIssues(&GitHubRepo{Name: "githubBackup", Owner: "Robpol86"})
+				assert.EqualError(err, "invalid JSON response from server")
+			})
+			assert.NoError(err)
+			if failOn == "issues" {
+				assert.Equal("Failed to query for issues.", logs.LastEntry().Message)
+			} else {
+				assert.Equal("Failed to query for issue comments.", logs.LastEntry().Message)
+			}
+		})
+	}
+}
+
+func TestAPI_ExportIssues(t *testing.T) {
+	assert := require.New(t)
+	ts := issuesServer(assert, "")
+	defer ts.Close()
+
+	tmpdir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpdir)
+
+	api := &API{TestURL: ts.URL}
+	ghRepo := &GitHubRepo{Name: "githubBackup", Owner: "Robpol86"}
+
+	// First run writes the file.
+	skipped, err := api.ExportIssues(ghRepo, tmpdir)
+	assert.NoError(err)
+	assert.False(skipped)
+	contents, err := ioutil.ReadFile(filepath.Join(tmpdir, IssuesFile))
+	assert.NoError(err)
+	var decoded []map[string]interface{}
+	assert.NoError(json.Unmarshal(contents, &decoded))
+	assert.Len(decoded, 2)
+	assert.Equal("Back up wikis", decoded[0]["issue"].(map[string]interface{})["title"])
+	assert.Len(decoded[0]["comments"], 2)
+
+	// Second run skips.
+	skipped, err = api.ExportIssues(ghRepo, tmpdir)
+	assert.NoError(err)
+	assert.True(skipped)
+}
+
+func TestAPI_ExportIssuesUpdated(t *testing.T) {
+	assert := require.New(t)
+	_, file, _, _ := runtime.Caller(0)
+	reply, err := ioutil.ReadFile(filepath.Join(filepath.Dir(file), "issues_test.json"))
+	assert.NoError(err)
+
+	// Issue 2 is edited and commented on and issue 3 is opened after the first run.
+	const issueURL = "https://api.github.com/repos/Robpol86/githubBackup/issues/"
+	updatedIssues := `[
+  {"url": "` + issueURL + `2", "number": 2, "title": "Back up wikis and pulls", "updated_at": "2016-12-22T10:00:00Z"},
+  {"url": "` + issueURL + `3", "number": 3, "title": "Windows support", "updated_at": "2016-12-22T11:00:00Z"}
+]`
+	comments := `[
+  {"id": 1, "body": "First.", "issue_url": "` + issueURL + `2", "updated_at": "2016-12-19T15:00:00Z"},
+  {"id": 3, "body": "Second.", "issue_url": "` + issueURL + `2", "updated_at": "2016-12-20T03:02:55Z"}
+]`
+	updatedComments := `[
+  {"id": 3, "body": "Second, edited.", "issue_url": "` + issueURL + `2", "updated_at": "2016-12-22T10:00:00Z"},
+  {"id": 4, "body": "Third.", "issue_url": "` + issueURL + `3", "updated_at": "2016-12-22T11:00:00Z"}
+]`
+	run := 1
+	var since []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		since = append(since, r.URL.Query().Get("since"))
+		switch {
+		case r.URL.Path == "/repos/Robpol86/githubBackup/issues" && run == 1:
+			w.Write(reply)
+		case r.URL.Path == "/repos/Robpol86/githubBackup/issues":
+			w.Write([]byte(updatedIssues))
+		case r.URL.Path == "/repos/Robpol86/githubBackup/issues/comments" && run == 1:
+			w.Write([]byte(comments))
+		case r.URL.Path == "/repos/Robpol86/githubBackup/issues/comments":
+			w.Write([]byte(updatedComments))
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer ts.Close()
+
+	tmpdir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpdir)
+	api := &API{TestURL: ts.URL}
+	ghRepo := &GitHubRepo{Name: "githubBackup", Owner: "Robpol86"}
+	read := func() (issues []GitHubIssue) {
+		contents, err := ioutil.ReadFile(filepath.Join(tmpdir, IssuesFile))
+		assert.NoError(err)
+		assert.NoError(json.Unmarshal(contents, &issues))
+		return
+	}
+
+	// First run exports everything.
+	skipped, err := api.ExportIssues(ghRepo, tmpdir)
+	assert.NoError(err)
+	assert.False(skipped)
+	assert.Equal([]string{"", ""}, since)
+	assert.Len(read(), 2)
+
+	// Second run only queries changes and merges them.
+	run, since = 2, nil
+	skipped, err = api.ExportIssues(ghRepo, tmpdir)
+	assert.NoError(err)
+	assert.False(skipped)
+	assert.Equal([]string{"2016-12-20T03:02:55Z", "2016-12-20T03:02:55Z"}, since)
+	issues := read()
+	assert.Len(issues, 3)
+	assert.Equal("Back up wikis and pulls", *issues[0].Issue.Title)
+	assert.Len(issues[0].Comments, 2)
+	assert.Equal("First.", *issues[0].Comments[0].Body)
+	assert.Equal("Second, edited.", *issues[0].Comments[1].Body)
+	assert.Equal("Initial release", *issues[1].Issue.Title)
+	assert.Equal("Windows support", *issues[2].Issue.Title)
+	assert.Len(issues[2].Comments, 1)
+	assert.Equal("Third.", *issues[2].Comments[0].Body)
+
+	// Third run gets the same issues and comments again (since is inclusive) and skips.
+	since = nil
+	skipped, err = api.ExportIssues(ghRepo, tmpdir)
+	assert.NoError(err)
+	assert.True(skipped)
+	assert.Equal([]string{"2016-12-22T11:00:00Z", "2016-12-22T11:00:00Z"}, since)
+	assert.Equal(issues, read())
+}
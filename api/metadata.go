@@ -0,0 +1,276 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/Robpol86/githubBackup/config"
+	"github.com/google/go-github/github"
+)
+
+// MetadataFile is the name of the file (in each repo's backup directory) that the repo's settings are written to.
+const MetadataFile = "metadata.json"
+
+// mediaTypeProtectionPreview is required for the branch protection API.
+const mediaTypeProtectionPreview = "application/vnd.github.loki-preview+json"
+
+// redacted replaces webhook secrets and credentials in the exported metadata.
+const redacted = "********"
+
+// RepoMetadata holds the configuration of one GitHub repository that isn't part of its git content. Sections that
+// require push or admin access to the repo are null if the token doesn't have it.
+type RepoMetadata struct {
+	Repository       *repository                 `json:"repository"` // Description, homepage, topics, merge settings...
+	Collaborators    []*github.User              `json:"collaborators"`
+	BranchProtection map[string]*json.RawMessage `json:"branch_protection"` // Keyed by branch name.
+	Hooks            []*github.Hook              `json:"hooks"`
+	DeployKeys       []*github.Key               `json:"deploy_keys"`
+}
+
+// getRaw queries one API path and decodes the response into v. For endpoints that the vendored go-github library
+// doesn't support (fully) yet.
+//
+// :param mediaType: Accept header required by preview APIs.
+func (a *API) getRaw(path, mediaType string, v interface{}) (*github.Response, error) {
+	client := a.getClient()
+	request, err := client.NewRequest("GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Accept", mediaType)
+	response, err := client.Do(request, v)
+	if err != nil {
+		return response, translateError(err)
+	}
+	a.noteRate(response)
+	return response, nil
+}
+
+// getCollaborators retrieves the collaborators of a repository with their permissions.
+func (a *API) getCollaborators(ghRepo *GitHubRepo) ([]*github.User, error) {
+	log := config.GetLogger().WithField("repo", ghRepo.Name)
+	client := a.getClient()
+
+	users := []*github.User{}
+	options := github.ListOptions{PerPage: 100}
+	for {
+		page, response, err := client.Repositories.ListCollaborators(ghRepo.Owner, ghRepo.Name, &options)
+		logWithFields := log.WithField("page", options.Page).WithField("numCollaborators", len(page))
+		logWithFields.WithField("response", response).Debug("Got response from GitHub collaborators API.")
+		if err != nil {
+			err = translateError(err)
+			logWithFields.WithField("error", err.Error()).Debug("Failed to query for collaborators.")
+			return nil, err
+		}
+		a.noteRate(response)
+		users = append(users, page...)
+		if response.NextPage == 0 {
+			break
+		}
+		options.Page = response.NextPage
+	}
+
+	return users, nil
+}
+
+// getHooks retrieves the webhooks of a repository with secrets and credentials in their config redacted.
+func (a *API) getHooks(ghRepo *GitHubRepo) ([]*github.Hook, error) {
+	log := config.GetLogger().WithField("repo", ghRepo.Name)
+	client := a.getClient()
+
+	hooks := []*github.Hook{}
+	options := github.ListOptions{PerPage: 100}
+	for {
+		page, response, err := client.Repositories.ListHooks(ghRepo.Owner, ghRepo.Name, &options)
+		logWithFields := log.WithField("page", options.Page).WithField("numHooks", len(page))
+		logWithFields.WithField("response", response).Debug("Got response from GitHub hooks API.")
+		if err != nil {
+			err = translateError(err)
+			logWithFields.WithField("error", err.Error()).Debug("Failed to query for hooks.")
+			return nil, err
+		}
+		a.noteRate(response)
+		hooks = append(hooks, page...)
+		if response.NextPage == 0 {
+			break
+		}
+		options.Page = response.NextPage
+	}
+
+	for _, hook := range hooks {
+		redactHook(hook)
+	}
+	return hooks, nil
+}
+
+// redactURL removes the credentials and replaces the query string of a webhook URL, which often hold tokens.
+// Unparsable URLs are replaced entirely.
+func redactURL(value string) string {
+	parsed, err := url.Parse(value)
+	if err != nil {
+		return redacted
+	}
+	parsed.User = nil
+	if parsed.RawQuery != "" {
+		parsed.RawQuery = redacted
+	}
+	return parsed.String()
+}
+
+// redactHook replaces secrets, passwords and tokens in a webhook's config. GitHub masks the secret itself but
+// services store credentials under other keys and in URLs.
+func redactHook(hook *github.Hook) {
+	for key, value := range hook.Config {
+		lower := strings.ToLower(key)
+		if value == nil || value == "" {
+			continue
+		}
+		if text, ok := value.(string); ok && strings.HasSuffix(lower, "url") {
+			hook.Config[key] = redactURL(text)
+			continue
+		}
+		for _, word := range []string{"secret", "password", "token", "key"} {
+			if strings.Contains(lower, word) {
+				hook.Config[key] = redacted
+				break
+			}
+		}
+	}
+}
+
+// getDeployKeys retrieves the (public) deploy keys of a repository.
+func (a *API) getDeployKeys(ghRepo *GitHubRepo) ([]*github.Key, error) {
+	log := config.GetLogger().WithField("repo", ghRepo.Name)
+	client := a.getClient()
+
+	keys := []*github.Key{}
+	options := github.ListOptions{PerPage: 100}
+	for {
+		page, response, err := client.Repositories.ListKeys(ghRepo.Owner, ghRepo.Name, &options)
+		logWithFields := log.WithField("page", options.Page).WithField("numKeys", len(page))
+		logWithFields.WithField("response", response).Debug("Got response from GitHub deploy keys API.")
+		if err != nil {
+			err = translateError(err)
+			logWithFields.WithField("error", err.Error()).Debug("Failed to query for deploy keys.")
+			return nil, err
+		}
+		a.noteRate(response)
+		keys = append(keys, page...)
+		if response.NextPage == 0 {
+			break
+		}
+		options.Page = response.NextPage
+	}
+
+	return keys, nil
+}
+
+// pathEscape escapes text for use as a single URL path segment (e.g. branch names with # or ?) like url.PathEscape(),
+// which needs Go 1.8.
+func pathEscape(text string) string {
+	return strings.Replace(url.QueryEscape(text), "+", "%20", -1)
+}
+
+// getBranchProtection retrieves the protection rules of every protected branch of a repository. The rules are kept as
+// returned by the API since the vendored go-github library only knows about some of them.
+func (a *API) getBranchProtection(ghRepo *GitHubRepo) (map[string]*json.RawMessage, error) {
+	log := config.GetLogger().WithField("repo", ghRepo.Name)
+	repoPath := fmt.Sprintf("repos/%v/%v", ghRepo.Owner, ghRepo.Name)
+
+	// List protected branches.
+	var branches []*github.Branch
+	query := url.Values{"per_page": {"100"}, "protected": {"true"}}
+	for page := 0; ; {
+		var pageBranches []*github.Branch
+		response, err := a.getRaw(repoPath+"/branches?"+query.Encode(), mediaTypeProtectionPreview, &pageBranches)
+		logWithFields := log.WithField("page", page).WithField("numBranches", len(pageBranches))
+		logWithFields.WithField("response", response).Debug("Got response from GitHub branches API.")
+		if err != nil {
+			logWithFields.WithField("error", err.Error()).Debug("Failed to query for protected branches.")
+			return nil, err
+		}
+		branches = append(branches, pageBranches...)
+		if response.NextPage == 0 {
+			break
+		}
+		page = response.NextPage
+		query.Set("page", strconv.Itoa(page))
+	}
+
+	// Get their rules.
+	protection := map[string]*json.RawMessage{}
+	for _, branch := range branches {
+		if branch.Protected != nil && !*branch.Protected {
+			continue // Old GitHub Enterprise versions ignore the protected parameter.
+		}
+		rules := &json.RawMessage{}
+		path := fmt.Sprintf("%s/branches/%s/protection", repoPath, pathEscape(*branch.Name))
+		response, err := a.getRaw(path, mediaTypeProtectionPreview, rules)
+		logWithFields := log.WithField("branch", *branch.Name)
+		logWithFields.WithField("response", response).Debug("Got response from GitHub branch protection API.")
+		if err != nil {
+			logWithFields.WithField("error", err.Error()).Debug("Failed to query for branch protection.")
+			return nil, err
+		}
+		protection[*branch.Name] = rules
+	}
+
+	return protection, nil
+}
+
+// GetMetadata retrieves the settings, topics, collaborators, branch protection rules, webhooks and deploy keys of a
+// repository. Collaborators need push access and the rest admin access, they're left out without it.
+//
+// :param ghRepo: Query metadata of this repo.
+func (a *API) GetMetadata(ghRepo *GitHubRepo) (*RepoMetadata, error) {
+	log := config.GetLogger().WithField("repo", ghRepo.Name)
+
+	metadata := RepoMetadata{Repository: &repository{}}
+	path := fmt.Sprintf("repos/%v/%v", ghRepo.Owner, ghRepo.Name)
+	response, err := a.getRaw(path, mediaTypeTopicsPreview, metadata.Repository)
+	log.WithField("response", response).Debug("Got response from GitHub repo API.")
+	if err != nil {
+		log.WithField("error", err.Error()).Debug("Failed to query for repo.")
+		return nil, err
+	}
+
+	var permissions map[string]bool
+	if metadata.Repository.Permissions != nil {
+		permissions = *metadata.Repository.Permissions
+	}
+	if permissions["push"] || permissions["admin"] {
+		if metadata.Collaborators, err = a.getCollaborators(ghRepo); err != nil {
+			return nil, err
+		}
+	}
+	if permissions["admin"] {
+		if metadata.BranchProtection, err = a.getBranchProtection(ghRepo); err != nil {
+			return nil, err
+		}
+		if metadata.Hooks, err = a.getHooks(ghRepo); err != nil {
+			return nil, err
+		}
+		if metadata.DeployKeys, err = a.getDeployKeys(ghRepo); err != nil {
+			return nil, err
+		}
+	}
+
+	return &metadata, nil
+}
+
+// ExportMetadata writes the metadata of a repository to a JSON file.
+//
+// :param ghRepo: Export metadata of this repo.
+//
+// :param dir: Directory to write the JSON file into.
+func (a *API) ExportMetadata(ghRepo *GitHubRepo, dir string) error {
+	metadata, err := a.GetMetadata(ghRepo)
+	if err != nil {
+		return err
+	}
+	return writeJSON(filepath.Join(dir, MetadataFile), metadata)
+}
@@ -0,0 +1,224 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/Robpol86/githubBackup/config"
+	"github.com/google/go-github/github"
+)
+
+// PullsDir is the name of the directory (in each repo's backup directory) that pull requests are written to, one JSON
+// file per pull request named after its number.
+const PullsDir = "pulls"
+
+// mediaTypeReviewsPreview is required for the pull request reviews API.
+const mediaTypeReviewsPreview = "application/vnd.github.black-cat-preview+json"
+
+// GitHubPull holds one GitHub pull request with its review comments (including diff positions), reviews and timeline
+// events.
+type GitHubPull struct {
+	Pull           *github.PullRequest          `json:"pull"`
+	ReviewComments []*github.PullRequestComment `json:"review_comments"`
+	Reviews        []*github.PullRequestReview  `json:"reviews"`
+	Timeline       []*github.Timeline           `json:"timeline"`
+}
+
+// GetPulls retrieves all open and closed pull requests of a repository.
+//
+// :param ghRepo: Query pull requests of this repo.
+func (a *API) GetPulls(ghRepo *GitHubRepo) ([]*github.PullRequest, error) {
+	log := config.GetLogger().WithField("repo", ghRepo.Name)
+	client := a.getClient()
+
+	var pulls []*github.PullRequest
+	options := github.PullRequestListOptions{State: "all", Direction: "asc"}
+	options.PerPage = 100
+	for {
+		page, response, err := client.PullRequests.List(ghRepo.Owner, ghRepo.Name, &options)
+		logWithFields := log.WithField("page", options.ListOptions.Page).WithField("numPulls", len(page))
+		logWithFields.WithField("response", response).Debug("Got response from GitHub pull requests API.")
+		if err != nil {
+			err = translateError(err)
+			logWithFields.WithField("error", err.Error()).Debug("Failed to query for pull requests.")
+			return nil, err
+		}
+		a.noteRate(response)
+		pulls = append(pulls, page...)
+		if response.NextPage == 0 {
+			break
+		}
+		options.ListOptions.Page = response.NextPage
+	}
+
+	return pulls, nil
+}
+
+// getReviewComments retrieves the review comments of all pull requests of a repository at once (instead of once per
+// pull request to save API calls). Returns them keyed by the pull request's API URL.
+func (a *API) getReviewComments(ghRepo *GitHubRepo) (map[string][]*github.PullRequestComment, error) {
+	log := config.GetLogger().WithField("repo", ghRepo.Name)
+	client := a.getClient()
+
+	comments := map[string][]*github.PullRequestComment{}
+	options := github.PullRequestListCommentsOptions{Sort: "created", Direction: "asc"}
+	options.PerPage = 100
+	for {
+		page, response, err := client.PullRequests.ListComments(ghRepo.Owner, ghRepo.Name, 0, &options)
+		logWithFields := log.WithField("page", options.ListOptions.Page).WithField("numComments", len(page))
+		logWithFields.WithField("response", response).Debug("Got response from GitHub review comments API.")
+		if err != nil {
+			err = translateError(err)
+			logWithFields.WithField("error", err.Error()).Debug("Failed to query for review comments.")
+			return nil, err
+		}
+		a.noteRate(response)
+		for _, comment := range page {
+			if comment.PullRequestURL != nil {
+				comments[*comment.PullRequestURL] = append(comments[*comment.PullRequestURL], comment)
+			}
+		}
+		if response.NextPage == 0 {
+			break
+		}
+		options.ListOptions.Page = response.NextPage
+	}
+
+	return comments, nil
+}
+
+// getReviews retrieves the reviews of one pull request. The vendored go-github library has no method for this yet.
+func (a *API) getReviews(ghRepo *GitHubRepo, number int) ([]*github.PullRequestReview, error) {
+	log := config.GetLogger().WithField("repo", ghRepo.Name).WithField("pull", number)
+	client := a.getClient()
+
+	reviews := []*github.PullRequestReview{}
+	path := fmt.Sprintf("repos/%v/%v/pulls/%d/reviews?per_page=100", ghRepo.Owner, ghRepo.Name, number)
+	for page := 0; ; {
+		pagePath := path
+		if page > 0 {
+			pagePath += "&page=" + strconv.Itoa(page)
+		}
+		request, err := client.NewRequest("GET", pagePath, nil)
+		if err != nil {
+			return nil, err
+		}
+		request.Header.Set("Accept", mediaTypeReviewsPreview)
+		var pageReviews []*github.PullRequestReview
+		response, err := client.Do(request, &pageReviews)
+		logWithFields := log.WithField("page", page).WithField("numReviews", len(pageReviews))
+		logWithFields.WithField("response", response).Debug("Got response from GitHub reviews API.")
+		if err != nil {
+			err = translateError(err)
+			logWithFields.WithField("error", err.Error()).Debug("Failed to query for reviews.")
+			return nil, err
+		}
+		a.noteRate(response)
+		reviews = append(reviews, pageReviews...)
+		if response.NextPage == 0 {
+			break
+		}
+		page = response.NextPage
+	}
+
+	return reviews, nil
+}
+
+// getTimeline retrieves the timeline events (commits, labels, references, merges, etc.) of one pull request.
+func (a *API) getTimeline(ghRepo *GitHubRepo, number int) ([]*github.Timeline, error) {
+	log := config.GetLogger().WithField("repo", ghRepo.Name).WithField("pull", number)
+	client := a.getClient()
+
+	events := []*github.Timeline{}
+	options := github.ListOptions{PerPage: 100}
+	for {
+		page, response, err := client.Issues.ListIssueTimeline(ghRepo.Owner, ghRepo.Name, number, &options)
+		logWithFields := log.WithField("page", options.Page).WithField("numEvents", len(page))
+		logWithFields.WithField("response", response).Debug("Got response from GitHub timeline API.")
+		if err != nil {
+			err = translateError(err)
+			logWithFields.WithField("error", err.Error()).Debug("Failed to query for timeline events.")
+			return nil, err
+		}
+		a.noteRate(response)
+		events = append(events, page...)
+		if response.NextPage == 0 {
+			break
+		}
+		options.Page = response.NextPage
+	}
+
+	return events, nil
+}
+
+// pullUnchanged returns true if path holds an export of the pull request made after its last update.
+func pullUnchanged(path string, pull *github.PullRequest) bool {
+	data, err := ioutil.ReadFile(path)
+	if err != nil || pull.UpdatedAt == nil {
+		return false
+	}
+	var exported GitHubPull
+	if err = json.Unmarshal(data, &exported); err != nil || exported.Pull == nil || exported.Pull.UpdatedAt == nil {
+		return false
+	}
+	return exported.Pull.UpdatedAt.Equal(*pull.UpdatedAt)
+}
+
+// ExportPulls writes every pull request of a repository with its review comments, reviews and timeline to
+// pulls/<number>.json. Pull requests that weren't updated since they were exported are skipped.
+//
+// :param ghRepo: Export pull requests of this repo.
+//
+// :param dir: Directory to create the pulls directory in.
+func (a *API) ExportPulls(ghRepo *GitHubRepo, dir string) (exported, skipped int, err error) {
+	log := config.GetLogger().WithField("repo", ghRepo.Name)
+	pulls, err := a.GetPulls(ghRepo)
+	if err != nil {
+		return
+	}
+
+	// Only query the rest for new or updated pull requests.
+	pullsDir := filepath.Join(dir, PullsDir)
+	var changed []*github.PullRequest
+	for _, pull := range pulls {
+		if pullUnchanged(filepath.Join(pullsDir, fmt.Sprintf("%d.json", *pull.Number)), pull) {
+			skipped++
+		} else {
+			changed = append(changed, pull)
+		}
+	}
+	if len(changed) == 0 {
+		return
+	}
+	if err = os.MkdirAll(pullsDir, os.ModePerm); err != nil {
+		return
+	}
+	comments, err := a.getReviewComments(ghRepo)
+	if err != nil {
+		return
+	}
+
+	for _, pull := range changed {
+		ghPull := GitHubPull{Pull: pull, ReviewComments: []*github.PullRequestComment{}}
+		if pull.URL != nil && comments[*pull.URL] != nil {
+			ghPull.ReviewComments = comments[*pull.URL]
+		}
+		if ghPull.Reviews, err = a.getReviews(ghRepo, *pull.Number); err != nil {
+			return
+		}
+		if ghPull.Timeline, err = a.getTimeline(ghRepo, *pull.Number); err != nil {
+			return
+		}
+		path := filepath.Join(pullsDir, fmt.Sprintf("%d.json", *pull.Number))
+		if err = writeJSON(path, ghPull); err != nil {
+			return
+		}
+		exported++
+	}
+	log.WithField("path", pullsDir).Debugf("Wrote %d pull requests (%d unchanged).", exported, skipped)
+	return
+}
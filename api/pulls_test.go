@@ -0,0 +1,99 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Robpol86/githubBackup/testUtils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPI_ExportPulls(t *testing.T) {
+	assert := require.New(t)
+
+	tmpdir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpdir)
+
+	// Setup mock HTTP server.
+	var requested []string
+	updatedAt := "2017-01-01T00:00:00Z"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.Path)
+		pull := `{"number": %[1]d, "title": "Pull %[1]d", "url": "%[2]s/repos/me/repo/pulls/%[1]d",
+			"updated_at": %[3]q}`
+		switch r.URL.Path {
+		case "/repos/me/repo/pulls":
+			assert.Equal("all", r.URL.Query().Get("state"))
+			host := "http://" + r.Host
+			first := fmt.Sprintf(pull, 1, host, "2016-12-01T00:00:00Z")
+			fmt.Fprintf(w, "[%s, %s]", first, fmt.Sprintf(pull, 2, host, updatedAt))
+		case "/repos/me/repo/pulls/comments":
+			fmt.Fprintf(w, `[{"id": 10, "body": "Nit.", "position": 4, "original_position": 3,
+				"diff_hunk": "@@ -1 +1 @@", "pull_request_url": "http://%s/repos/me/repo/pulls/1"}]`, r.Host)
+		case "/repos/me/repo/pulls/1/reviews", "/repos/me/repo/pulls/2/reviews":
+			assert.Equal(mediaTypeReviewsPreview, r.Header.Get("Accept"))
+			w.Write([]byte(`[{"id": 20, "state": "APPROVED"}]`))
+		case "/repos/me/repo/issues/1/timeline", "/repos/me/repo/issues/2/timeline":
+			w.Write([]byte(`[{"id": 30, "event": "merged"}]`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message": "Not Found"}`))
+		}
+	}))
+	defer ts.Close()
+
+	ghAPI := API{TestURL: ts.URL}
+	ghRepo := GitHubRepo{Name: "repo", Owner: "me"}
+	export := func() (int, int) {
+		var exported, skipped int
+		_, _, _, err := testUtils.WithLogging(func() {
+			var err error
+			exported, skipped, err = ghAPI.ExportPulls(&ghRepo, tmpdir)
+			assert.NoError(err)
+		})
+		assert.NoError(err)
+		return exported, skipped
+	}
+
+	// First export.
+	exported, skipped := export()
+	assert.Equal(2, exported)
+	assert.Equal(0, skipped)
+	data, err := ioutil.ReadFile(filepath.Join(tmpdir, PullsDir, "1.json"))
+	assert.NoError(err)
+	var ghPull GitHubPull
+	assert.NoError(json.Unmarshal(data, &ghPull))
+	assert.Equal("Pull 1", *ghPull.Pull.Title)
+	assert.Len(ghPull.ReviewComments, 1)
+	assert.Equal(4, *ghPull.ReviewComments[0].Position)
+	assert.Equal(3, *ghPull.ReviewComments[0].OriginalPosition)
+	assert.Equal("APPROVED", *ghPull.Reviews[0].State)
+	assert.Equal("merged", *ghPull.Timeline[0].Event)
+	data, err = ioutil.ReadFile(filepath.Join(tmpdir, PullsDir, "2.json"))
+	assert.NoError(err)
+	assert.Contains(string(data), `"review_comments": []`)
+
+	// Nothing changed.
+	requested = nil
+	exported, skipped = export()
+	assert.Equal(0, exported)
+	assert.Equal(2, skipped)
+	assert.Equal([]string{"/repos/me/repo/pulls"}, requested)
+
+	// Pull request 2 was updated.
+	updatedAt = "2017-02-01T00:00:00Z"
+	requested = nil
+	exported, skipped = export()
+	assert.Equal(1, exported)
+	assert.Equal(1, skipped)
+	expected := []string{"/repos/me/repo/pulls", "/repos/me/repo/pulls/comments", "/repos/me/repo/pulls/2/reviews",
+		"/repos/me/repo/issues/2/timeline"}
+	assert.Equal(expected, requested)
+}
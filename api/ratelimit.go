@@ -0,0 +1,153 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Robpol86/githubBackup/config"
+)
+
+// Overridden in tests.
+var (
+	sleep            = time.Sleep
+	now              = time.Now
+	progressInterval = time.Minute
+)
+
+// resetMargin is added to waits until the rate limit resets to account for clock differences with GitHub.
+const resetMargin = 2 * time.Second
+
+// rateLimitTransport is an http.RoundTripper that waits until GitHub resets the API rate limit instead of letting
+// requests fail. Handles the primary rate limit (X-RateLimit-* headers) and secondary/abuse limits (Retry-After).
+//
+// Shared by concurrent requests. Once one request finds the rate limit exhausted the others wait too instead of being
+// rejected one by one.
+type rateLimitTransport struct {
+	base         http.RoundTripper
+	mu           sync.Mutex
+	blockedUntil time.Time
+	reason       string // Why requests are blocked. Logged by the next request to wait, empty if already logged.
+}
+
+// wait sleeps for the duration while logging progress every progressInterval.
+func wait(duration time.Duration, reason string) {
+	log := config.GetLogger().WithField("reason", reason)
+	until := now().Add(duration)
+	log.WithField("until", until).Warnf("%s. Waiting %s before continuing.", reason, duration/time.Second*time.Second)
+	for remaining := duration; remaining > 0; remaining = until.Sub(now()) {
+		if remaining > progressInterval {
+			sleep(progressInterval)
+			log.Infof("Still waiting for the GitHub API, %s left.", until.Sub(now())/time.Second*time.Second)
+		} else {
+			sleep(remaining)
+			break
+		}
+	}
+	log.Info("Done waiting, continuing.")
+}
+
+// retryAfter returns how long GitHub asks clients to back off for secondary rate limits, or 0 if it didn't.
+func retryAfter(response *http.Response) time.Duration {
+	if response.StatusCode != http.StatusForbidden && response.StatusCode != http.StatusTooManyRequests {
+		return 0
+	}
+	seconds, err := strconv.Atoi(response.Header.Get("Retry-After"))
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// untilReset returns how long until the primary rate limit resets if the response used up the last request, or 0
+// otherwise. Responses without rate limit headers (e.g. from test servers or proxies) are ignored.
+func untilReset(response *http.Response) time.Duration {
+	if response.Header.Get("X-RateLimit-Remaining") != "0" {
+		return 0
+	}
+	reset, err := strconv.ParseInt(response.Header.Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return 0
+	}
+	duration := time.Unix(reset, 0).Sub(now())
+	if duration <= 0 {
+		return 0
+	}
+	return duration + resetMargin
+}
+
+// limit makes the next requests wait for the duration, without holding back the current one.
+func (t *rateLimitTransport) limit(duration time.Duration, reason string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if until := now().Add(duration); until.After(t.blockedUntil) {
+		t.blockedUntil = until
+		t.reason = reason
+	}
+}
+
+// block waits for the duration and makes other requests wait until it's over too.
+func (t *rateLimitTransport) block(duration time.Duration, reason string) {
+	t.mu.Lock()
+	if until := now().Add(duration); until.After(t.blockedUntil) {
+		t.blockedUntil = until
+		t.reason = "" // Logged below.
+	}
+	t.mu.Unlock()
+	wait(duration, reason)
+}
+
+// waitIfBlocked holds back a request while the rate limit is exhausted. The first request to wait after limit() logs
+// why and its progress, the others wait quietly.
+func (t *rateLimitTransport) waitIfBlocked() {
+	t.mu.Lock()
+	duration := t.blockedUntil.Sub(now())
+	reason := t.reason
+	if duration > 0 {
+		t.reason = ""
+	}
+	t.mu.Unlock()
+	if duration <= 0 {
+		return
+	}
+	if reason != "" {
+		wait(duration, reason)
+		return
+	}
+	config.GetLogger().WithField("duration", duration).Debug("Holding back request until the rate limit resets.")
+	sleep(duration)
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *rateLimitTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	for {
+		t.waitIfBlocked()
+		response, err := t.base.RoundTrip(request)
+		if err != nil {
+			return response, err
+		}
+
+		// Requests with a body can't be replayed. Let the caller handle the error.
+		retryable := request.Body == nil
+
+		// Secondary rate limit.
+		if duration := retryAfter(response); duration > 0 && retryable {
+			response.Body.Close()
+			t.block(duration, "GitHub asked to slow down (secondary rate limit)")
+			continue
+		}
+
+		// Primary rate limit. Either this request was rejected or it was the last one allowed before the reset (then
+		// only the next request waits).
+		if duration := untilReset(response); duration > 0 {
+			if response.StatusCode == http.StatusForbidden && retryable {
+				response.Body.Close()
+				t.block(duration, "GitHub API rate limit exceeded")
+				continue
+			}
+			t.limit(duration, "GitHub API rate limit used up")
+		}
+		return response, nil
+	}
+}
@@ -0,0 +1,159 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Robpol86/githubBackup/testUtils"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock replaces sleep() and now() so tests don't actually wait. Returns a function that undoes the patch.
+func fakeClock(clock *time.Time, slept *[]time.Duration) func() {
+	oldSleep, oldNow := sleep, now
+	sleep = func(d time.Duration) { *slept = append(*slept, d); *clock = clock.Add(d) }
+	now = func() time.Time { return *clock }
+	return func() { sleep, now = oldSleep, oldNow }
+}
+
+func TestRateLimitTransport(t *testing.T) {
+	testCases := []struct {
+		name     string
+		status   int
+		headers  map[string]string
+		expected []time.Duration // Sleeps.
+		requests int
+	}{
+		{"no headers", 200, map[string]string{}, nil, 1},
+		{"remaining", 200, map[string]string{"X-RateLimit-Remaining": "1", "X-RateLimit-Reset": "+150"}, nil, 1},
+		{"used up", 200, map[string]string{"X-RateLimit-Remaining": "0", "X-RateLimit-Reset": "+150"}, nil, 1},
+		{
+			"exceeded", 403, map[string]string{"X-RateLimit-Remaining": "0", "X-RateLimit-Reset": "+30"},
+			[]time.Duration{32 * time.Second}, 2,
+		},
+		{"reset passed", 200, map[string]string{"X-RateLimit-Remaining": "0", "X-RateLimit-Reset": "-5"}, nil, 1},
+		{"retry after", 403, map[string]string{"Retry-After": "60"}, []time.Duration{time.Minute}, 2},
+		{"too many requests", 429, map[string]string{"Retry-After": "5"}, []time.Duration{5 * time.Second}, 2},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert := require.New(t)
+			clock := time.Unix(1500000000, 0)
+			var slept []time.Duration
+			defer fakeClock(&clock, &slept)()
+
+			// Reply with the headers the first time only.
+			var requests int
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				if requests == 1 {
+					for key, value := range tc.headers {
+						if key == "X-RateLimit-Reset" {
+							offset, _ := strconv.Atoi(value)
+							value = strconv.FormatInt(clock.Unix()+int64(offset), 10)
+						}
+						w.Header().Set(key, value)
+					}
+					w.WriteHeader(tc.status)
+					if tc.status != 200 {
+						w.Write([]byte(`{"message": "API rate limit exceeded", "documentation_url": ""}`))
+						return
+					}
+				}
+				w.Write([]byte("[]"))
+			}))
+			defer ts.Close()
+
+			// Run.
+			logs, stdout, stderr, err := testUtils.WithLogging(func() {
+				api := &API{TestURL: ts.URL, User: "me"}
+				assert.NoError(api.GetRepos(&GitHubRepos{}))
+			})
+			assert.NoError(err)
+			assert.Empty(stdout)
+			assert.Empty(stderr)
+
+			// Verify.
+			assert.Equal(tc.expected, slept)
+			assert.Equal(tc.requests, requests)
+			if tc.expected != nil {
+				assert.Contains(logs.Entries[0].Message, ". Waiting ")
+				assert.Equal("Done waiting, continuing.", logs.Entries[len(tc.expected)].Message)
+			}
+		})
+	}
+}
+
+func TestRateLimitTransportShared(t *testing.T) {
+	assert := require.New(t)
+	clock := time.Unix(1500000000, 0)
+	var slept []time.Duration
+	defer fakeClock(&clock, &slept)()
+
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte("[]"))
+	}))
+	defer ts.Close()
+
+	// All clients share one transport.
+	api := &API{TestURL: ts.URL, User: "me"}
+	api.getClient()
+	httpClient := api.httpClient
+	api.getClient()
+	assert.True(httpClient == api.httpClient)
+
+	// Another request is waiting for the rate limit to reset.
+	httpClient.Transport.(*rateLimitTransport).blockedUntil = clock.Add(30 * time.Second)
+	_, _, _, err := testUtils.WithLogging(func() {
+		assert.NoError(api.GetRepos(&GitHubRepos{}))
+		assert.NoError(api.GetRepos(&GitHubRepos{}))
+	})
+	assert.NoError(err)
+	assert.Equal([]time.Duration{30 * time.Second}, slept)
+	assert.Equal(2, requests)
+}
+
+func TestRateLimitTransportUsedUp(t *testing.T) {
+	assert := require.New(t)
+	clock := time.Unix(1500000000, 0)
+	var slept []time.Duration
+	defer fakeClock(&clock, &slept)()
+
+	// The first request uses up the rate limit.
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(clock.Unix()+150, 10))
+		}
+		w.Write([]byte("[]"))
+	}))
+	defer ts.Close()
+	api := &API{TestURL: ts.URL, User: "me"}
+
+	// Its response is returned right away.
+	_, _, _, err := testUtils.WithLogging(func() {
+		assert.NoError(api.GetRepos(&GitHubRepos{}))
+	})
+	assert.NoError(err)
+	assert.Empty(slept)
+	assert.Equal(1, requests)
+	assert.Equal(clock.Add(152*time.Second), api.httpClient.Transport.(*rateLimitTransport).blockedUntil)
+
+	// The next request waits.
+	logs, _, _, err := testUtils.WithLogging(func() {
+		assert.NoError(api.GetRepos(&GitHubRepos{}))
+	})
+	assert.NoError(err)
+	assert.Equal([]time.Duration{time.Minute, time.Minute, 32 * time.Second}, slept)
+	assert.Equal(2, requests)
+	assert.Equal("GitHub API rate limit used up. Waiting 2m32s before continuing.", logs.Entries[0].Message)
+	assert.Equal("Done waiting, continuing.", logs.Entries[3].Message)
+}
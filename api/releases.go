@@ -0,0 +1,186 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Robpol86/githubBackup/config"
+	"github.com/google/go-github/github"
+)
+
+// ReleasesFile is the name of the file (in each repo's backup directory) that release metadata is written to.
+const ReleasesFile = "releases.json"
+
+// ReleasesDir is the name of the directory (in each repo's backup directory) that release assets are downloaded into.
+const ReleasesDir = "releases"
+
+// downloadTimeout aborts release asset downloads that don't receive any data for this long. Large assets may take
+// longer than that in total, they just mustn't stall.
+var downloadTimeout = 5 * time.Minute
+
+// stallReader calls abort if a Read on the wrapped body doesn't return within downloadTimeout.
+type stallReader struct {
+	io.ReadCloser
+	timer *time.Timer
+}
+
+func newStallReader(body io.ReadCloser, abort func()) *stallReader {
+	return &stallReader{ReadCloser: body, timer: time.AfterFunc(downloadTimeout, abort)}
+}
+
+func (r *stallReader) Read(p []byte) (int, error) {
+	r.timer.Reset(downloadTimeout)
+	n, err := r.ReadCloser.Read(p)
+	r.timer.Stop()
+	return n, err
+}
+
+func (r *stallReader) Close() error {
+	r.timer.Stop()
+	return r.ReadCloser.Close()
+}
+
+// safeName makes tag and asset names safe to use as a single path component.
+func safeName(name string) string {
+	name = strings.Replace(strings.Replace(name, "/", "_", -1), "\\", "_", -1)
+	if name == "" || name == "." || name == ".." {
+		name = "_" + name
+	}
+	return name
+}
+
+// GetReleases retrieves metadata of all releases (including drafts and pre-releases) and their assets of a repository.
+//
+// :param ghRepo: Query releases of this repo.
+func (a *API) GetReleases(ghRepo *GitHubRepo) ([]*github.RepositoryRelease, error) {
+	log := config.GetLogger().WithField("repo", ghRepo.Name)
+	client := a.getClient()
+
+	var releases []*github.RepositoryRelease
+	options := github.ListOptions{PerPage: 100}
+	for {
+		page, response, err := client.Repositories.ListReleases(ghRepo.Owner, ghRepo.Name, &options)
+		logWithFields := log.WithField("page", options.Page).WithField("numReleases", len(page))
+		logWithFields.WithField("response", response).Debug("Got response from GitHub releases API.")
+		if err != nil {
+			err = translateError(err)
+			logWithFields.WithField("error", err.Error()).Debug("Failed to query for releases.")
+			return nil, err
+		}
+		a.noteRate(response)
+		releases = append(releases, page...)
+		if response.NextPage == 0 {
+			break
+		}
+		options.Page = response.NextPage
+	}
+
+	return releases, nil
+}
+
+// downloadAsset downloads one release asset into path. GitHub usually redirects to a pre-signed URL on a different host
+// which doesn't need authentication. The redirect must not be followed by the authenticated client (it would send the
+// token to that host) so every download gets its own client, see getDownloadClient().
+func (a *API) downloadAsset(ghRepo *GitHubRepo, asset *github.ReleaseAsset, path string) error {
+	client := a.getDownloadClient()
+	rc, redirectURL, err := client.Repositories.DownloadReleaseAsset(ghRepo.Owner, ghRepo.Name, *asset.ID)
+	if err != nil {
+		return translateError(err)
+	}
+	if redirectURL != "" {
+		request, err := http.NewRequest("GET", redirectURL, nil)
+		if err != nil {
+			return err
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		timer := time.AfterFunc(downloadTimeout, cancel) // Until the response headers arrive.
+		response, err := http.DefaultClient.Do(request.WithContext(ctx))
+		timer.Stop()
+		if err != nil {
+			return err
+		}
+		if response.StatusCode != http.StatusOK {
+			response.Body.Close()
+			return fmt.Errorf("GET %s: %s", redirectURL, response.Status)
+		}
+		rc = newStallReader(response.Body, cancel)
+	} else {
+		body := rc
+		rc = newStallReader(body, func() { body.Close() })
+	}
+	defer rc.Close()
+
+	handle, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(handle, rc)
+	handle.Close()
+	if err != nil {
+		os.Remove(handle.Name())
+		return err
+	}
+	return os.Rename(handle.Name(), path)
+}
+
+// ExportReleases writes release metadata of a repository to a JSON file and downloads every release asset into
+// releases/<tag>/. Assets that were already downloaded (same file size) are skipped. Assets that fail to download are
+// counted in failed and the rest are still downloaded, err then lists all of the failures.
+//
+// :param ghRepo: Export releases of this repo.
+//
+// :param dir: Directory to write the JSON file and releases directory into.
+func (a *API) ExportReleases(ghRepo *GitHubRepo, dir string) (downloaded, skipped, failed int, err error) {
+	log := config.GetLogger().WithField("repo", ghRepo.Name)
+	releases, err := a.GetReleases(ghRepo)
+	if err != nil {
+		return
+	}
+	if err = writeJSON(filepath.Join(dir, ReleasesFile), releases); err != nil {
+		return
+	}
+
+	var failures []string
+	for _, release := range releases {
+		tag := fmt.Sprintf("draft-%d", *release.ID) // Drafts may not have a tag yet.
+		if release.TagName != nil && *release.TagName != "" {
+			tag = *release.TagName
+		}
+		tagDir := filepath.Join(dir, ReleasesDir, safeName(tag))
+		for i := range release.Assets {
+			asset := &release.Assets[i]
+			path := filepath.Join(tagDir, safeName(*asset.Name))
+			logAsset := log.WithField("path", path)
+			if stat, err := os.Stat(path); err == nil && asset.Size != nil && stat.Size() == int64(*asset.Size) {
+				logAsset.Debug("Release asset already downloaded, skipping.")
+				skipped++
+				continue
+			}
+			logAsset.Debug("Downloading release asset.")
+			err := os.MkdirAll(tagDir, os.ModePerm)
+			if err == nil {
+				err = a.downloadAsset(ghRepo, asset, path)
+			}
+			if err != nil {
+				logAsset.WithField("error", err.Error()).Warn("Failed to download release asset.")
+				failures = append(failures, fmt.Sprintf("%s/%s: %s", tag, *asset.Name, err.Error()))
+				failed++
+				continue
+			}
+			downloaded++
+		}
+	}
+
+	if failed > 0 {
+		err = errors.New("failed to download release assets: " + strings.Join(failures, "; "))
+	}
+	return
+}
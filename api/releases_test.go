@@ -0,0 +1,241 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Robpol86/githubBackup/testUtils"
+	"github.com/stretchr/testify/require"
+)
+
+const releasesReply = `[
+  {
+    "id": 2, "tag_name": "v2.0.0", "name": "Two", "body": "Notes.", "draft": false, "prerelease": true,
+    "assets": [
+      {"id": 20, "name": "app.tar.gz", "size": 9, "content_type": "application/gzip"},
+      {"id": 21, "name": "../app.zip", "size": 10, "content_type": "application/zip"}
+    ]
+  },
+  {"id": 1, "tag_name": "", "name": "Draft", "draft": true, "assets": [{"id": 10, "name": "a.txt", "size": 1}]}
+]`
+
+func releasesServer(assert *require.Assertions, requested *[]string) *httptest.Server {
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requested = append(*requested, r.URL.Path)
+		switch r.URL.Path {
+		case "/repos/me/repo/releases":
+			w.Write([]byte(releasesReply))
+		case "/repos/me/repo/releases/assets/20":
+			assert.Equal("application/octet-stream", r.Header.Get("Accept"))
+			w.Write([]byte("tarball!!"))
+		case "/repos/me/repo/releases/assets/21":
+			http.Redirect(w, r, ts.URL+"/s3/app.zip", http.StatusFound)
+		case "/s3/app.zip":
+			w.Write([]byte("zip file!!"))
+		case "/repos/me/repo/releases/assets/10":
+			w.Write([]byte("a"))
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	return ts
+}
+
+func TestSafeName(t *testing.T) {
+	assert := require.New(t)
+	assert.Equal("v1.0.0", safeName("v1.0.0"))
+	assert.Equal("release_1.0", safeName("release/1.0"))
+	assert.Equal(".._.._x", safeName("../../x"))
+	assert.Equal("_..", safeName(".."))
+	assert.Equal("_", safeName(""))
+}
+
+func TestAPI_ExportReleases(t *testing.T) {
+	assert := require.New(t)
+	var requested []string
+	ts := releasesServer(assert, &requested)
+	defer ts.Close()
+
+	tmpdir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpdir)
+
+	api := &API{TestURL: ts.URL}
+	ghRepo := &GitHubRepo{Name: "repo", Owner: "me"}
+
+	// First run downloads everything.
+	_, stdout, stderr, err := testUtils.WithLogging(func() {
+		downloaded, skipped, failed, err := api.ExportReleases(ghRepo, tmpdir)
+		assert.NoError(err)
+		assert.Equal(3, downloaded)
+		assert.Equal(0, skipped)
+		assert.Equal(0, failed)
+	})
+	assert.NoError(err)
+	assert.Empty(stdout)
+	assert.Empty(stderr)
+
+	// Verify files.
+	expected := map[string]string{
+		filepath.Join("v2.0.0", "app.tar.gz"): "tarball!!",
+		filepath.Join("v2.0.0", ".._app.zip"): "zip file!!",
+		filepath.Join("draft-1", "a.txt"):     "a",
+	}
+	for path, contents := range expected {
+		actual, err := ioutil.ReadFile(filepath.Join(tmpdir, ReleasesDir, path))
+		assert.NoError(err)
+		assert.Equal(contents, string(actual))
+	}
+	contents, err := ioutil.ReadFile(filepath.Join(tmpdir, ReleasesFile))
+	assert.NoError(err)
+	var decoded []map[string]interface{}
+	assert.NoError(json.Unmarshal(contents, &decoded))
+	assert.Len(decoded, 2)
+	assert.Equal(true, decoded[0]["prerelease"])
+
+	// Second run skips assets with matching sizes.
+	assert.NoError(ioutil.WriteFile(filepath.Join(tmpdir, ReleasesDir, "draft-1", "a.txt"), []byte("ab"), 0644))
+	requested = nil
+	downloaded, skipped, _, err := api.ExportReleases(ghRepo, tmpdir)
+	assert.NoError(err)
+	assert.Equal(1, downloaded)
+	assert.Equal(2, skipped)
+	assert.Equal([]string{"/repos/me/repo/releases", "/repos/me/repo/releases/assets/10"}, requested)
+}
+
+func TestAPI_ExportReleasesConcurrent(t *testing.T) {
+	assert := require.New(t)
+	var mu sync.Mutex
+	var leaked []string
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/releases"):
+			w.Write([]byte(releasesReply))
+		case strings.HasPrefix(r.URL.Path, "/s3/"):
+			if r.Header.Get("Authorization") != "" {
+				mu.Lock()
+				leaked = append(leaked, r.URL.Path)
+				mu.Unlock()
+			}
+			w.Write([]byte("zip file!!"))
+		default: // Every asset redirects.
+			http.Redirect(w, r, ts.URL+"/s3"+r.URL.Path, http.StatusFound)
+		}
+	}))
+	defer ts.Close()
+
+	tmpdir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpdir)
+
+	// Repos share the API's HTTP client when backed up concurrently (--jobs).
+	api := &API{TestURL: ts.URL, Token: "abc"}
+	_, _, _, err = testUtils.WithLogging(func() {
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				dir := filepath.Join(tmpdir, strconv.Itoa(i))
+				assert.NoError(os.Mkdir(dir, os.ModePerm))
+				ghRepo := &GitHubRepo{Name: "repo" + strconv.Itoa(i), Owner: "me"}
+				downloaded, _, _, err := api.ExportReleases(ghRepo, dir)
+				assert.NoError(err)
+				assert.Equal(3, downloaded)
+			}(i)
+		}
+		wg.Wait()
+	})
+	assert.NoError(err)
+	assert.Empty(leaked) // The token must never be sent to the redirect host.
+}
+
+func TestAPI_ExportReleasesBad(t *testing.T) {
+	assert := require.New(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/repos/me/repo/releases" {
+			w.Write([]byte(releasesReply))
+		} else {
+			w.WriteHeader(500)
+		}
+	}))
+	defer ts.Close()
+
+	tmpdir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpdir)
+
+	logs, _, _, err := testUtils.WithLogging(func() {
+		api := &API{TestURL: ts.URL}
+		downloaded, _, failed, err := api.ExportReleases(&GitHubRepo{Name: "repo", Owner: "me"}, tmpdir)
+		assert.Error(err)
+		assert.Equal(0, downloaded)
+		assert.Equal(3, failed) // Every asset is tried.
+		assert.Contains(err.Error(), "failed to download release assets: v2.0.0/app.tar.gz: ")
+		assert.Contains(err.Error(), "; draft-1/a.txt: ")
+	})
+	assert.NoError(err)
+	var warnings int
+	for _, entry := range logs.Entries {
+		if entry.Message == "Failed to download release asset." {
+			warnings++
+		}
+	}
+	assert.Equal(3, warnings)
+}
+
+func TestAPI_ExportReleasesStalled(t *testing.T) {
+	assert := require.New(t)
+	defer func(timeout time.Duration) { downloadTimeout = timeout }(downloadTimeout)
+	downloadTimeout = 100 * time.Millisecond
+
+	done := make(chan struct{})
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/me/repo/releases":
+			w.Write([]byte(releasesReply))
+		case "/repos/me/repo/releases/assets/21":
+			http.Redirect(w, r, ts.URL+"/s3/app.zip", http.StatusFound)
+		case "/s3/app.zip":
+			w.Write([]byte("zip"))
+			w.(http.Flusher).Flush()
+			select { // Stall until the test is done.
+			case <-done:
+			case <-time.After(10 * time.Second):
+			}
+		default:
+			w.Write([]byte("a"))
+		}
+	}))
+	defer ts.Close()
+	defer close(done) // Before ts.Close() which waits for the stalled handler.
+
+	tmpdir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpdir)
+
+	_, _, _, err = testUtils.WithLogging(func() {
+		api := &API{TestURL: ts.URL}
+		start := time.Now()
+		downloaded, _, failed, err := api.ExportReleases(&GitHubRepo{Name: "repo", Owner: "me"}, tmpdir)
+		assert.Error(err)
+		assert.True(time.Since(start) < 5*time.Second)
+		assert.Equal(2, downloaded)
+		assert.Equal(1, failed)
+	})
+	assert.NoError(err)
+	_, err = os.Stat(filepath.Join(tmpdir, ReleasesDir, "v2.0.0", ".._app.zip"))
+	assert.True(os.IsNotExist(err))
+}
@@ -1,24 +1,45 @@
 package api
 
 import (
-	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/Robpol86/githubBackup/config"
+	"github.com/Sirupsen/logrus"
 	"github.com/google/go-github/github"
 )
 
 // GitHubRepo holds data for one GitHub repository.
 type GitHubRepo struct {
 	Name      string
+	Owner     string
+	Org       string // Set if the repo was listed with --org. Its backup goes into that organization's directory.
+	Starred   bool   // Set if the repo was listed with --starred. Only the repo itself is cloned.
+	Shared    bool   // Set if another user owns the repo (listed with --affiliation). Backed up into their directory.
 	Size      int
 	Fork      bool
 	Private   bool
 	PushedAt  time.Time
+	UpdatedAt time.Time
 	CloneURL  string
 	WikiURL   string
 	HasIssues bool
+	Archived  bool
+	Topics    []string
+	Language  string // Primary language. Empty if GitHub couldn't detect one.
+}
+
+// mediaTypeTopicsPreview is required for the API to include topics in repo listings.
+const mediaTypeTopicsPreview = "application/vnd.github.mercy-preview+json"
+
+// repository adds fields to github.Repository that the vendored go-github library doesn't know about yet.
+type repository struct {
+	github.Repository
+	Archived *bool    `json:"archived,omitempty"`
+	Topics   []string `json:"topics,omitempty"`
 }
 
 // GitHubRepos is a slice of GitHubRepo with attached convenience function receivers.
@@ -27,13 +48,14 @@ type GitHubRepos []GitHubRepo
 // Counts returns the number of repos (values) for specific types/categories (keys).
 func (g *GitHubRepos) Counts() map[string]int {
 	counts := map[string]int{
-		"all":     0,
-		"public":  0,
-		"private": 0,
-		"sources": 0,
-		"forks":   0,
-		"wikis":   0,
-		"issues":  0,
+		"all":      0,
+		"public":   0,
+		"private":  0,
+		"sources":  0,
+		"forks":    0,
+		"wikis":    0,
+		"issues":   0,
+		"archived": 0,
 	}
 	for _, repo := range *g {
 		counts["all"]++
@@ -53,25 +75,47 @@ func (g *GitHubRepos) Counts() map[string]int {
 		if repo.HasIssues {
 			counts["issues"]++
 		}
+		if repo.Archived {
+			counts["archived"]++
+		}
 	}
 	return counts
 }
 
-func (a *API) parseRepo(repo *github.Repository, ghRepos *GitHubRepos) {
+// index returns the position of a repo in the slice or -1 if it's not there.
+func (g *GitHubRepos) index(owner, name string) int {
+	for i, repo := range *g {
+		if repo.Owner == owner && repo.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (a *API) parseRepo(repo *repository, org string, ghRepos *GitHubRepos) {
 	ghRepo := GitHubRepo{
 		Name:      *repo.Name,
+		Owner:     *repo.Owner.Login,
+		Org:       org,
 		Size:      *repo.Size,
 		Fork:      *repo.Fork,
 		Private:   *repo.Private,
 		PushedAt:  repo.PushedAt.Time,
+		UpdatedAt: repo.UpdatedAt.Time,
 		CloneURL:  *repo.CloneURL,
 		HasIssues: *repo.HasIssues,
+		Archived:  repo.Archived != nil && *repo.Archived,
+		Topics:    repo.Topics,
+	}
+	if repo.Language != nil {
+		ghRepo.Language = *repo.Language
 	}
 
-	// If private use SSH clone url instead of HTTPS.
-	if *repo.Private {
+	// Use the SSH clone URL for private repos unless --clone-protocol says otherwise.
+	if a.CloneProtocol == "ssh" || (a.CloneProtocol != "https" && *repo.Private) {
 		ghRepo.CloneURL = *repo.SSHURL
 	}
+	ghRepo.CloneURL = a.cloneURL(ghRepo.CloneURL)
 
 	// If it has a wiki get the right clone URL for that.
 	if !a.NoWikis && *repo.HasWiki {
@@ -86,6 +130,121 @@ func (a *API) parseRepo(repo *github.Repository, ghRepos *GitHubRepos) {
 	*ghRepos = append(*ghRepos, ghRepo)
 }
 
+// containsFold returns true if value is in values, ignoring case.
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasTopic returns true if any of the repo's topics is one of the --topic values.
+func (a *API) hasTopic(repo *repository) bool {
+	for _, topic := range repo.Topics {
+		if containsFold(a.Topics, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterReason returns why a repo is skipped by --no-archived, --topic, --language or --max-size (one of the Skip*
+// constants), or an empty string if it isn't.
+func (a *API) filterReason(repo *repository) string {
+	if a.NoArchived && repo.Archived != nil && *repo.Archived {
+		return SkipArchived
+	}
+	if len(a.Topics) > 0 && !a.hasTopic(repo) {
+		return SkipTopic
+	}
+	if len(a.Languages) > 0 && (repo.Language == nil || !containsFold(a.Languages, *repo.Language)) {
+		return SkipLanguage
+	}
+	if a.MaxSize > 0 && *repo.Size > a.MaxSize {
+		return SkipSize
+	}
+	return ""
+}
+
+// parseRepos filters out unwanted repos and adds the rest to ghRepos.
+//
+// :param org: Organization the repos were listed from. Empty string for user repos.
+func (a *API) parseRepos(repos []*repository, org string, log *logrus.Entry, ghRepos *GitHubRepos) {
+	for _, repo := range repos {
+		if org != "" {
+			// Authenticated users also get repos of organizations they're a member of. Don't back them up twice.
+			if i := ghRepos.index(*repo.Owner.Login, *repo.Name); i >= 0 {
+				log.Debugf("Moving duplicate repo into org directory: %s", *repo.Name)
+				(*ghRepos)[i].Org = org
+				continue
+			}
+		}
+		if repo.MirrorURL != nil {
+			log.Debugf("Skipping mirrored repo: %s", *repo.Name)
+		} else if reason := a.skipReason(*repo.Name, *repo.Owner.Login+"/"+*repo.Name); reason != "" {
+			log.Debugf("Skipping filtered repo: %s (%s)", *repo.Name, reason)
+		} else if reason := a.filterReason(repo); reason != "" {
+			log.Debugf("Skipping filtered repo: %s (%s)", *repo.Name, reason)
+			a.skipped(reason)
+		} else if a.NoForks && *repo.Fork {
+			log.Debugf("Skipping forked repo: %s", *repo.Name)
+		} else if a.NoPublic && !*repo.Private {
+			log.Debugf("Skipping public repo: %s", *repo.Name)
+		} else if a.NoPrivate && *repo.Private {
+			log.Debugf("Skipping private repo: %s", *repo.Name)
+		} else {
+			a.parseRepo(repo, org, ghRepos)
+			ghRepo := &(*ghRepos)[len(*ghRepos)-1]
+			if org == "" && a.Login != "" && ghRepo.Owner != a.Login {
+				// Shared with the authenticated user. Keep it apart from the user's own repos since names may clash.
+				if repo.Owner.Type != nil && *repo.Owner.Type == "Organization" {
+					ghRepo.Org = ghRepo.Owner
+				} else {
+					ghRepo.Shared = true
+				}
+			}
+		}
+	}
+}
+
+// listRepos queries one page of a repo listing. Replaces client.Repositories.List(), ListByOrg() and
+// Activity.ListStarred() which don't decode the archived and topics fields.
+//
+// :param path: API path relative to the base URL, e.g. user/repos.
+//
+// :param query: Query string parameters (per_page, page, type or visibility).
+func listRepos(client *github.Client, path string, query url.Values) ([]*repository, *github.Response, error) {
+	request, err := client.NewRequest("GET", path+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	request.Header.Set("Accept", mediaTypeTopicsPreview)
+
+	var repos []*repository
+	response, err := client.Do(request, &repos)
+	if err != nil {
+		return nil, response, err
+	}
+	return repos, response, nil
+}
+
+// getLogin looks up the login of the authenticated user and stores it in a.Login.
+func (a *API) getLogin() error {
+	log := config.GetLogger()
+	user, response, err := a.getClient().Users.Get("")
+	log.WithField("response", response).Debug("Got response from GitHub user API.")
+	if err != nil {
+		err = translateError(err)
+		log.WithField("error", err.Error()).Debug("Failed to query for the authenticated user.")
+		return err
+	}
+	a.noteRate(response)
+	a.Login = *user.Login
+	return nil
+}
+
 // GetRepos retrieves the list of public and private GitHub repos on the user's account.
 //
 // :param ghRepos: Add repos to this.
@@ -94,52 +253,99 @@ func (a *API) GetRepos(ghRepos *GitHubRepos) error {
 	client := a.getClient()
 
 	// Configure request options.
-	options := github.RepositoryListOptions{}
-	options.PerPage = 100
+	path := "user/repos"
+	if a.User != "" {
+		path = fmt.Sprintf("users/%v/repos", a.User)
+	}
+	query := url.Values{"per_page": {"100"}}
 	if a.NoPrivate {
-		options.Visibility = "public"
+		query.Set("visibility", "public")
 	} else if a.NoPublic {
-		options.Visibility = "private"
+		query.Set("visibility", "private")
 	}
 
-	for {
+	if a.User == "" && a.Affiliation != "" {
+		query.Set("affiliation", a.Affiliation)
+	}
+
+	for page := 0; ; {
 		// Query API.
-		repos, response, err := client.Repositories.List(a.User, &options)
-		logWithFields := log.WithField("page", options.ListOptions.Page).WithField("numRepos", len(repos))
+		repos, response, err := listRepos(client, path, query)
+		logWithFields := log.WithField("page", page).WithField("numRepos", len(repos))
 		logWithFields.WithField("response", response).Debug("Got response from GitHub repos API.")
 		if err != nil {
-			if strings.HasPrefix(err.Error(), "invalid character ") {
-				err = errors.New("invalid JSON response from server")
-			}
+			err = translateError(err)
 			logWithFields.WithField("error", err.Error()).Debug("Failed to query for repos.")
 			return err
 		}
 
 		// Note rate limiting.
-		a.Limit = response.Limit
-		a.Remaining = response.Remaining
-		a.Reset = response.Reset
+		a.noteRate(response)
 
-		// Parse.
-		for _, repo := range repos {
-			if repo.MirrorURL != nil {
-				logWithFields.Debugf("Skipping mirrored repo: %s", *repo.Name)
-			} else if a.NoForks && *repo.Fork {
-				logWithFields.Debugf("Skipping forked repo: %s", *repo.Name)
-			} else if a.NoPublic && !*repo.Private {
-				logWithFields.Debugf("Skipping public repo: %s", *repo.Name)
-			} else if a.NoPrivate && *repo.Private {
-				logWithFields.Debugf("Skipping private repo: %s", *repo.Name)
-			} else {
-				a.parseRepo(repo, ghRepos)
+		// The authenticated user's repo listing includes repos of other owners (collaborator and organization member
+		// affiliations by default). They're told apart by the authenticated user's login.
+		if a.User == "" && a.Token != "" && a.Login == "" {
+			if err := a.getLogin(); err != nil {
+				return err
 			}
 		}
 
+		// Parse.
+		a.parseRepos(repos, "", logWithFields, ghRepos)
+
 		// Next page or exit.
 		if response.NextPage == 0 {
 			break
 		}
-		options.ListOptions.Page = response.NextPage
+		page = response.NextPage
+		query.Set("page", strconv.Itoa(page))
+	}
+
+	return nil
+}
+
+// GetOrgRepos retrieves the list of public and private GitHub repos of every organization in a.Orgs.
+//
+// :param ghRepos: Add repos to this.
+func (a *API) GetOrgRepos(ghRepos *GitHubRepos) error {
+	log := config.GetLogger()
+	client := a.getClient()
+
+	// Configure request options.
+	query := url.Values{"per_page": {"100"}}
+	if a.NoPrivate {
+		query.Set("type", "public")
+	} else if a.NoPublic {
+		query.Set("type", "private")
+	}
+
+	for _, org := range a.Orgs {
+		query.Del("page")
+		for page := 0; ; {
+			// Query API.
+			repos, response, err := listRepos(client, fmt.Sprintf("orgs/%v/repos", org), query)
+			logWithFields := log.WithField("org", org).WithField("page", page)
+			logWithFields = logWithFields.WithField("numRepos", len(repos))
+			logWithFields.WithField("response", response).Debug("Got response from GitHub org repos API.")
+			if err != nil {
+				err = translateError(err)
+				logWithFields.WithField("error", err.Error()).Debug("Failed to query for org repos.")
+				return err
+			}
+
+			// Note rate limiting.
+			a.noteRate(response)
+
+			// Parse.
+			a.parseRepos(repos, org, logWithFields, ghRepos)
+
+			// Next page or exit.
+			if response.NextPage == 0 {
+				break
+			}
+			page = response.NextPage
+			query.Set("page", strconv.Itoa(page))
+		}
 	}
 
 	return nil
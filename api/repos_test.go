@@ -120,22 +120,22 @@ func TestAPI_GetReposFilters(t *testing.T) {
 			switch no {
 			case "forks":
 				expected = map[string]int{"all": 2, "public": 1, "private": 1, "sources": 2,
-					"forks": 0, "wikis": 1, "issues": 2}
+					"forks": 0, "wikis": 1, "issues": 2, "archived": 0}
 			case "issues":
 				expected = map[string]int{"all": 3, "public": 2, "private": 1, "sources": 2,
-					"forks": 1, "wikis": 1, "issues": 0}
+					"forks": 1, "wikis": 1, "issues": 0, "archived": 0}
 			case "private":
 				expected = map[string]int{"all": 2, "public": 2, "private": 0, "sources": 1,
-					"forks": 1, "wikis": 0, "issues": 1}
+					"forks": 1, "wikis": 0, "issues": 1, "archived": 0}
 			case "public":
 				expected = map[string]int{"all": 1, "public": 0, "private": 1, "sources": 1,
-					"forks": 0, "wikis": 1, "issues": 1}
+					"forks": 0, "wikis": 1, "issues": 1, "archived": 0}
 			case "wikis":
 				expected = map[string]int{"all": 3, "public": 2, "private": 1, "sources": 2,
-					"forks": 1, "wikis": 0, "issues": 2}
+					"forks": 1, "wikis": 0, "issues": 2, "archived": 0}
 			default:
 				expected = map[string]int{"all": 3, "public": 2, "private": 1, "sources": 2,
-					"forks": 1, "wikis": 1, "issues": 2}
+					"forks": 1, "wikis": 1, "issues": 2, "archived": 0}
 			}
 			assert.Equal(expected, ghRepos.Counts())
 		})
@@ -190,3 +190,184 @@ func TestAPI_GetReposPagination(t *testing.T) {
 	sort.Strings(actual)
 	assert.Equal(expected, actual)
 }
+
+func TestAPI_GetOrgRepos(t *testing.T) {
+	assert := require.New(t)
+	_, file, _, _ := runtime.Caller(0)
+	reply, err := ioutil.ReadFile(filepath.Join(filepath.Dir(file), "repos_test.json"))
+	assert.NoError(err)
+
+	// HTTP response.
+	var requests []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.String())
+		if r.URL.Path == "/orgs/unknown/repos" {
+			w.WriteHeader(404)
+			w.Write([]byte(`{"message": "Not Found", "documentation_url": ""}`))
+			return
+		}
+		w.Write(reply)
+	}))
+	defer ts.Close()
+
+	// Run.
+	ghRepos := GitHubRepos{}
+	stdout, stderr, err := testUtils.WithCapSys(func() {
+		api := &API{TestURL: ts.URL, NoPrivate: true, Orgs: []string{"org1", "org2"}}
+		assert.NoError(api.GetOrgRepos(&ghRepos))
+	})
+	assert.Empty(stdout)
+	assert.Empty(stderr)
+	assert.NoError(err)
+
+	// Verify.
+	assert.Equal([]string{"/orgs/org1/repos?per_page=100&type=public", "/orgs/org2/repos?per_page=100&type=public"}, requests)
+	assert.Equal(map[string]int{"all": 2, "public": 2, "private": 0, "sources": 1, "forks": 1, "wikis": 0,
+		"issues": 1, "archived": 0}, ghRepos.Counts())
+	for _, repo := range ghRepos {
+		assert.Equal("org2", repo.Org) // Same JSON reply for both orgs so org2 takes over.
+	}
+
+	// User repos that are also org repos.
+	ghRepos = GitHubRepos{}
+	_, _, err = testUtils.WithCapSys(func() {
+		api := &API{TestURL: ts.URL}
+		assert.NoError(api.GetRepos(&ghRepos))
+		api.Orgs = []string{"org1"}
+		assert.NoError(api.GetOrgRepos(&ghRepos))
+	})
+	assert.NoError(err)
+	assert.Len(ghRepos, 3)
+	for _, repo := range ghRepos {
+		assert.Equal("org1", repo.Org)
+	}
+
+	// Error.
+	_, _, err = testUtils.WithCapSys(func() {
+		api := &API{TestURL: ts.URL, Orgs: []string{"unknown"}}
+		err := api.GetOrgRepos(&GitHubRepos{})
+		assert.EqualError(err, fmt.Sprintf("GET %s/orgs/unknown/repos?per_page=100: 404 Not Found []", ts.URL))
+	})
+	assert.NoError(err)
+}
+
+func TestAPI_GetReposAffiliation(t *testing.T) {
+	assert := require.New(t)
+	repo := `{"name": "%s", "owner": {"login": "%s", "type": "%s"}, "size": 1, "fork": false, "private": false,
+		"pushed_at": "2017-01-01T00:00:00Z", "updated_at": "2017-01-01T00:00:00Z",
+		"clone_url": "https://github.com/%[2]s/%[1]s.git", "has_issues": false, "has_wiki": false}`
+	reply := "[" + strings.Join([]string{
+		fmt.Sprintf(repo, "mine", "me", "User"),
+		fmt.Sprintf(repo, "shared", "friend", "User"),
+		fmt.Sprintf(repo, "work", "company", "Organization"),
+	}, ",") + "]"
+
+	var requests []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.String())
+		if r.URL.Path == "/user" {
+			w.Write([]byte(`{"login": "me"}`))
+		} else {
+			w.Write([]byte(reply))
+		}
+	}))
+	defer ts.Close()
+
+	// Run.
+	ghRepos := GitHubRepos{}
+	api := &API{TestURL: ts.URL, Affiliation: "owner,collaborator,organization_member", Token: "abc"}
+	_, _, _, err := testUtils.WithLogging(func() {
+		assert.NoError(api.GetRepos(&ghRepos))
+	})
+	assert.NoError(err)
+
+	// Verify.
+	expected := []string{"/user/repos?affiliation=owner%2Ccollaborator%2Corganization_member&per_page=100", "/user"}
+	assert.Equal(expected, requests)
+	assert.Equal("me", api.Login)
+	assert.Equal(2, api.Requests)
+	assert.Len(ghRepos, 3)
+	assert.Equal([]string{"", "", "company"}, []string{ghRepos[0].Org, ghRepos[1].Org, ghRepos[2].Org})
+	assert.Equal([]bool{false, true, false}, []bool{ghRepos[0].Shared, ghRepos[1].Shared, ghRepos[2].Shared})
+}
+
+func TestAPI_GetReposOtherOwners(t *testing.T) {
+	assert := require.New(t)
+	_, file, _, _ := runtime.Caller(0)
+	reply, err := ioutil.ReadFile(filepath.Join(filepath.Dir(file), "repos_test.json"))
+	assert.NoError(err)
+	mirrorURL := `"mirror_url": "https://chromium.googlesource.com/angle/angle"`
+	reply = []byte(strings.Replace(string(reply), mirrorURL, `"mirror_url": null`, 1)) // Not a mirror.
+
+	var requests []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Path)
+		if r.URL.Path == "/user" {
+			w.Write([]byte(`{"login": "Robpol86"}`))
+		} else {
+			w.Write(reply)
+		}
+	}))
+	defer ts.Close()
+
+	// Without --affiliation GitHub still lists repos of other owners. Don't mix them up with the user's repos.
+	for _, token := range []string{"abc", ""} {
+		requests = nil
+		ghRepos := GitHubRepos{}
+		api := &API{TestURL: ts.URL, Token: token}
+		_, _, _, err := testUtils.WithLogging(func() {
+			assert.NoError(api.GetRepos(&ghRepos))
+		})
+		assert.NoError(err)
+		assert.Len(ghRepos, 4)
+		i := ghRepos.index("google", "angle")
+		assert.True(i >= 0)
+		if token == "" {
+			// Can't tell without authentication (the API doesn't list other owners' repos then anyway).
+			assert.Equal([]string{"/user/repos"}, requests)
+			assert.Equal("", ghRepos[i].Org)
+			continue
+		}
+		assert.Equal([]string{"/user/repos", "/user"}, requests)
+		assert.Equal("Robpol86", api.Login)
+		assert.Equal("google", ghRepos[i].Org)
+		for _, repo := range ghRepos {
+			if repo.Owner == "Robpol86" {
+				assert.Equal("", repo.Org)
+				assert.False(repo.Shared)
+			}
+		}
+	}
+}
+
+func TestAPI_GetReposCloneProtocol(t *testing.T) {
+	_, file, _, _ := runtime.Caller(0)
+	reply, err := ioutil.ReadFile(filepath.Join(filepath.Dir(file), "repos_test.json"))
+	require.NoError(t, err)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write(reply)
+	}))
+	defer ts.Close()
+
+	for _, protocol := range []string{"", "auto", "https", "ssh"} {
+		t.Run(protocol, func(t *testing.T) {
+			assert := require.New(t)
+			ghRepos := GitHubRepos{}
+			_, _, _, err := testUtils.WithLogging(func() {
+				api := &API{TestURL: ts.URL, CloneProtocol: protocol}
+				assert.NoError(api.GetRepos(&ghRepos))
+			})
+			assert.NoError(err)
+
+			for _, repo := range ghRepos {
+				ssh := protocol == "ssh" || (protocol != "https" && repo.Private)
+				assert.Equal(ssh, strings.HasPrefix(repo.CloneURL, "git@github.com:Robpol86/"), repo.CloneURL)
+				assert.Equal(!ssh, strings.HasPrefix(repo.CloneURL, "https://github.com/Robpol86/"), repo.CloneURL)
+				if repo.WikiURL != "" {
+					assert.Equal(strings.TrimSuffix(repo.CloneURL, ".git")+".wiki.git", repo.WikiURL)
+				}
+			}
+		})
+	}
+}
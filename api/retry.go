@@ -0,0 +1,74 @@
+package api
+
+import (
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/Robpol86/githubBackup/config"
+)
+
+// retryDelay is the backoff before the first retry. It doubles on every following attempt. Overridden in tests.
+var retryDelay = time.Second
+
+// retryTransport is an http.RoundTripper that retries requests failing for transient reasons: 5xx responses,
+// connection resets and timeouts.
+type retryTransport struct {
+	base    http.RoundTripper
+	retries int
+}
+
+// isTransient returns true if the error from a round trip is worth retrying.
+func isTransient(err error) bool {
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return true
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true // Server closed the connection without responding.
+	}
+	if opErr, ok := err.(*net.OpError); ok {
+		if sysErr, ok := opErr.Err.(*os.SyscallError); ok {
+			return sysErr.Err == syscall.ECONNRESET
+		}
+	}
+	return false
+}
+
+// backoff returns the delay before the retry following the given attempt (starting at 0). Exponential with jitter so
+// concurrent clients don't retry in lockstep.
+func backoff(attempt int) time.Duration {
+	delay := retryDelay << uint(attempt)
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *retryTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	log := config.GetLogger().WithField("url", request.URL.String())
+	for attempt := 0; ; attempt++ {
+		response, err := t.base.RoundTrip(request)
+
+		// Decide whether to retry.
+		var reason string
+		if err != nil && isTransient(err) {
+			reason = err.Error()
+		} else if err == nil && response.StatusCode >= 500 {
+			reason = response.Status
+		}
+		if reason == "" || attempt >= t.retries || request.Body != nil {
+			return response, err
+		}
+		if response != nil {
+			response.Body.Close()
+		}
+
+		// Wait and retry.
+		delay := backoff(attempt)
+		msg := "GitHub API request failed (%s). Retrying in %s (attempt %d of %d)."
+		log.WithField("attempt", attempt+1).Warnf(msg, reason, delay/time.Millisecond*time.Millisecond, attempt+1, t.retries)
+		sleep(delay)
+	}
+}
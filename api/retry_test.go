@@ -0,0 +1,118 @@
+package api
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/Robpol86/githubBackup/testUtils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackoff(t *testing.T) {
+	assert := require.New(t)
+	for attempt, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second} {
+		for i := 0; i < 20; i++ {
+			delay := backoff(attempt)
+			assert.True(delay >= expected/2 && delay <= expected, delay.String())
+		}
+	}
+}
+
+func TestAPI_GetReposRetry(t *testing.T) {
+	assert := require.New(t)
+	_, file, _, _ := runtime.Caller(0)
+	reply, err := ioutil.ReadFile(filepath.Join(filepath.Dir(file), "repos_test.json"))
+	assert.NoError(err)
+	clock := time.Now()
+	var slept []time.Duration
+	defer fakeClock(&clock, &slept)()
+
+	// HTTP response. Fails every other request, alternating between a 502 and a dropped connection.
+	var requests []string
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Query().Get("page"))
+		switch len(requests) {
+		case 1:
+			w.WriteHeader(502)
+			w.Write([]byte(`{"message": "Server Error", "documentation_url": ""}`))
+		case 3:
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+		case 2:
+			w.Header().Set("Connection", "close") // Otherwise net/http itself retries on the dropped connection.
+			w.Header().Add("Link", fmt.Sprintf(linksFormat(ts.URL+"/user/repos?per_page=100"), 2, 2))
+			w.Write(reply)
+		default:
+			w.Write(reply)
+		}
+	}))
+	defer ts.Close()
+
+	// Run.
+	ghRepos := GitHubRepos{}
+	logs, stdout, stderr, err := testUtils.WithLogging(func() {
+		api := &API{TestURL: ts.URL, Retries: 3}
+		assert.NoError(api.GetRepos(&ghRepos))
+	})
+	assert.NoError(err)
+	assert.Empty(stdout)
+	assert.Empty(stderr)
+
+	// Verify.
+	assert.Equal([]string{"", "", "2", "2"}, requests)
+	assert.Len(ghRepos, 6)
+	assert.Len(slept, 2)
+	var warnings []string
+	for _, entry := range logs.Entries {
+		if entry.Data["attempt"] != nil {
+			warnings = append(warnings, entry.Message)
+		}
+	}
+	assert.Len(warnings, 2)
+	assert.Contains(warnings[0], "GitHub API request failed (502 Bad Gateway). Retrying in ")
+	assert.Contains(warnings[1], "(attempt 1 of 3).")
+}
+
+func TestAPI_GetReposRetryGiveUp(t *testing.T) {
+	for _, status := range []int{503, 404} {
+		t.Run(fmt.Sprint(status), func(t *testing.T) {
+			assert := require.New(t)
+			clock := time.Now()
+			var slept []time.Duration
+			defer fakeClock(&clock, &slept)()
+
+			// HTTP response.
+			var requests int
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				w.WriteHeader(status)
+				w.Write([]byte(`{"message": "Nope", "documentation_url": ""}`))
+			}))
+			defer ts.Close()
+
+			// Run.
+			_, _, err := testUtils.WithCapSys(func() {
+				api := &API{TestURL: ts.URL, Retries: 2}
+				err := api.GetRepos(&GitHubRepos{})
+				assert.EqualError(err, fmt.Sprintf("GET %s/user/repos?per_page=100: %d Nope []", ts.URL, status))
+			})
+			assert.NoError(err)
+
+			// Verify.
+			if status == 503 {
+				assert.Equal(3, requests)
+				assert.Len(slept, 2)
+			} else {
+				assert.Equal(1, requests)
+				assert.Empty(slept)
+			}
+		})
+	}
+}
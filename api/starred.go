@@ -0,0 +1,78 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/Robpol86/githubBackup/config"
+	"github.com/Sirupsen/logrus"
+)
+
+// parseStarred filters out unwanted starred repos and adds the rest to ghStarred. Only the repos themselves are backed
+// up, without wikis, issues or releases.
+//
+// :param ghRepos: Repos already collected. Starred repos among them aren't added again.
+func (a *API) parseStarred(repos []*repository, log *logrus.Entry, ghRepos, ghStarred *GitHubRepos) {
+	for _, repo := range repos {
+		fullName := *repo.Owner.Login + "/" + *repo.Name
+		if ghRepos.index(*repo.Owner.Login, *repo.Name) >= 0 || ghStarred.index(*repo.Owner.Login, *repo.Name) >= 0 {
+			log.Debugf("Skipping starred repo already backed up: %s", fullName)
+		} else if reason := a.skipReason(*repo.Name, fullName); reason != "" {
+			log.Debugf("Skipping filtered starred repo: %s (%s)", fullName, reason)
+		} else if reason := a.filterReason(repo); reason != "" {
+			log.Debugf("Skipping filtered starred repo: %s (%s)", fullName, reason)
+			a.skipped(reason)
+		} else {
+			a.parseRepo(repo, "", ghStarred)
+			ghRepo := &(*ghStarred)[len(*ghStarred)-1]
+			ghRepo.Starred = true
+			ghRepo.WikiURL = ""
+			ghRepo.HasIssues = false
+		}
+	}
+}
+
+// GetStarred retrieves the list of GitHub repos starred by the user.
+//
+// :param ghRepos: Repos already collected by GetRepos() and GetOrgRepos(). They're not added to ghStarred.
+//
+// :param ghStarred: Add starred repos to this.
+func (a *API) GetStarred(ghRepos, ghStarred *GitHubRepos) error {
+	log := config.GetLogger()
+	client := a.getClient()
+
+	// Configure request options.
+	path := "user/starred"
+	if a.User != "" {
+		path = fmt.Sprintf("users/%v/starred", a.User)
+	}
+	query := url.Values{"per_page": {"100"}}
+
+	for page := 0; ; {
+		// Query API.
+		repos, response, err := listRepos(client, path, query)
+		logWithFields := log.WithField("page", page).WithField("numRepos", len(repos))
+		logWithFields.WithField("response", response).Debug("Got response from GitHub starred repos API.")
+		if err != nil {
+			err = translateError(err)
+			logWithFields.WithField("error", err.Error()).Debug("Failed to query for starred repos.")
+			return err
+		}
+
+		// Note rate limiting.
+		a.noteRate(response)
+
+		// Parse.
+		a.parseStarred(repos, logWithFields, ghRepos, ghStarred)
+
+		// Next page or exit.
+		if response.NextPage == 0 {
+			break
+		}
+		page = response.NextPage
+		query.Set("page", strconv.Itoa(page))
+	}
+
+	return nil
+}
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Robpol86/githubBackup/testUtils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPI_GetStarred(t *testing.T) {
+	assert := require.New(t)
+	repo := `{"name": "%s", "owner": {"login": "%s"}, "size": %d, "fork": false, "private": false,
+		"pushed_at": "2017-01-01T00:00:00Z", "updated_at": "2017-01-01T00:00:00Z",
+		"clone_url": "https://github.com/%[2]s/%[1]s.git", "has_issues": true, "has_wiki": true}`
+	reply := "[" + strings.Join([]string{
+		fmt.Sprintf(repo, "mine", "me", 10),
+		fmt.Sprintf(repo, "lib", "upstream", 20),
+		fmt.Sprintf(repo, "huge", "upstream", 5000),
+		fmt.Sprintf(repo, "tool-old", "other", 30),
+	}, ",") + "]"
+
+	var paths []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.String())
+		w.Write([]byte(reply))
+	}))
+	defer ts.Close()
+
+	ghRepos := GitHubRepos{{Name: "mine", Owner: "me"}}
+	ghStarred := GitHubRepos{}
+	logs, stdout, stderr, err := testUtils.WithLogging(func() {
+		api := &API{TestURL: ts.URL, User: "me", Excludes: []string{"*-old"}, MaxSize: 1000}
+		assert.NoError(api.GetStarred(&ghRepos, &ghStarred))
+		assert.Equal(map[string]int{SkipSize: 1}, api.SkippedRepos)
+	})
+	assert.NoError(err)
+	assert.Empty(stdout)
+	assert.Empty(stderr)
+
+	// Verify.
+	assert.Equal([]string{"/users/me/starred?per_page=100"}, paths)
+	assert.Len(ghRepos, 1)
+	assert.Equal(GitHubRepos{{Name: "lib", Owner: "upstream", Starred: true, Size: 20,
+		PushedAt: ghStarred[0].PushedAt, UpdatedAt: ghStarred[0].UpdatedAt,
+		CloneURL: "https://github.com/upstream/lib.git"}}, ghStarred)
+	var messages []string
+	for _, entry := range logs.Entries {
+		messages = append(messages, entry.Message)
+	}
+	assert.Contains(messages, "Skipping starred repo already backed up: me/mine")
+	assert.Contains(messages, "Skipping filtered starred repo: upstream/huge (too large)")
+	assert.Contains(messages, "Skipping filtered starred repo: other/tool-old (matches --exclude *-old)")
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Token sources in the order they're tried. The interactive prompt is last.
+const (
+	TokenSourceFlag       = "--token"
+	TokenSourceFile       = "--token-file"
+	TokenSourceEnv        = "GITHUB_TOKEN"
+	TokenSourceCredential = "git credential"
+	TokenSourcePrompt     = "prompt"
+)
+
+// readTokenFile reads a token from a file, ignoring surrounding whitespace.
+func readTokenFile(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed reading token file: %s", err.Error())
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("token file is empty: %s", path)
+	}
+	return token, nil
+}
+
+// gitCredential asks git's configured credential helpers for the password of https://host. Returns an empty string if
+// git isn't installed or no helper has one. Never prompts.
+func gitCredential(host string) string {
+	cmd := exec.Command("git", "credential", "fill")
+	cmd.Stdin = strings.NewReader("protocol=https\nhost=" + host + "\n\n")
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "GIT_ASKPASS=", "SSH_ASKPASS=", "GCM_INTERACTIVE=never")
+	output, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		if strings.HasPrefix(line, "password=") {
+			return strings.TrimSpace(strings.TrimPrefix(line, "password="))
+		}
+	}
+	return ""
+}
+
+// resolveToken looks for a token in non-interactive sources: --token, --token-file, the GITHUB_TOKEN environment
+// variable and finally git credential helpers. Sets a.Token and a.TokenSource if one is found.
+//
+// :param tokenFile: Path from --token-file.
+func (a *API) resolveToken(tokenFile string) error {
+	if a.Token != "" {
+		a.TokenSource = TokenSourceFlag
+		return nil
+	}
+	if tokenFile != "" {
+		token, err := readTokenFile(tokenFile)
+		if err != nil {
+			return err
+		}
+		a.Token, a.TokenSource = token, TokenSourceFile
+		return nil
+	}
+	if token := strings.TrimSpace(os.Getenv("GITHUB_TOKEN")); token != "" {
+		a.Token, a.TokenSource = token, TokenSourceEnv
+		return nil
+	}
+	if token := gitCredential(a.gitHost()); token != "" {
+		a.Token, a.TokenSource = token, TokenSourceCredential
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Robpol86/githubBackup/config"
+	"github.com/Robpol86/githubBackup/testUtils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewAPITokenSources(t *testing.T) {
+	assert := require.New(t)
+	tmpdir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpdir)
+	tokenFile := filepath.Join(tmpdir, "token")
+	assert.NoError(ioutil.WriteFile(tokenFile, []byte("  fromfile\n"), 0600))
+
+	// Git credential helper only answering for one host.
+	helper := `!f() { grep -q host=ghe.example.com && echo username=me && echo password=fromgit; }; f`
+	gitConfig := "[credential]\n\thelper = \"" + helper + "\"\n"
+	assert.NoError(ioutil.WriteFile(filepath.Join(tmpdir, ".gitconfig"), []byte(gitConfig), 0600))
+	gheURL := "https://ghe.example.com/api/v3/"
+
+	testCases := []struct {
+		name     string
+		cfg      config.Config
+		env      string
+		expected string
+		source   string
+	}{
+		{"flag", config.Config{Token: "fromflag", TokenFile: tokenFile}, "fromenv", "fromflag", TokenSourceFlag},
+		{"file", config.Config{TokenFile: tokenFile, APIURL: gheURL}, "fromenv", "fromfile", TokenSourceFile},
+		{"env", config.Config{APIURL: gheURL}, "fromenv", "fromenv", TokenSourceEnv},
+		{"credential", config.Config{APIURL: gheURL}, "", "fromgit", TokenSourceCredential},
+		{"prompt", config.Config{}, "", "fromprompt", TokenSourcePrompt},
+		{"none", config.Config{User: "me", NoPrompt: true}, "", "", ""},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert := require.New(t)
+			var api API
+			env := map[string]string{"GITHUB_TOKEN": tc.env, "HOME": tmpdir, "XDG_CONFIG_HOME": tmpdir}
+			stdout, _, err := testUtils.WithCapSys(func() {
+				testUtils.WithEnv(env, func() {
+					api, err = NewAPI(tc.cfg, "fromprompt")
+					assert.NoError(err)
+				})
+			})
+			assert.NoError(err)
+			assert.Equal(tc.expected, api.Token)
+			assert.Equal(tc.source, api.TokenSource)
+			assert.Equal(tc.source, api.Fields()["TokenSource"])
+			assert.Equal(tc.name == "prompt", stdout != "")
+			for _, value := range api.Fields() {
+				if tc.expected != "" {
+					assert.NotEqual(tc.expected, value)
+				}
+			}
+		})
+	}
+}
+
+func TestNewAPITokenFileError(t *testing.T) {
+	assert := require.New(t)
+	tmpdir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpdir)
+	tokenFile := filepath.Join(tmpdir, "token")
+
+	_, err = NewAPI(config.Config{TokenFile: tokenFile}, "")
+	assert.Error(err)
+	assert.Contains(err.Error(), "failed reading token file: ")
+
+	assert.NoError(ioutil.WriteFile(tokenFile, []byte("\n"), 0600))
+	_, err = NewAPI(config.Config{TokenFile: tokenFile}, "")
+	assert.EqualError(err, "token file is empty: "+tokenFile)
+}
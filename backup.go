@@ -0,0 +1,463 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Robpol86/githubBackup/api"
+	"github.com/Robpol86/githubBackup/clone"
+	"github.com/Robpol86/githubBackup/config"
+	"github.com/Sirupsen/logrus"
+)
+
+// repoDir returns the local directory holding everything backed up for one repository. Organization, starred and
+// other users' repos are kept apart from the user's repos since their names may clash.
+func repoDir(dest string, repo api.GitHubRepo) string {
+	if repo.Starred {
+		return filepath.Join(dest, "starred", repo.Owner, repo.Name)
+	}
+	if repo.Shared {
+		return filepath.Join(dest, "users", repo.Owner, repo.Name)
+	}
+	if repo.Org != "" {
+		return filepath.Join(dest, "orgs", repo.Org, repo.Name)
+	}
+	return filepath.Join(dest, repo.Name)
+}
+
+// gistDir returns the local directory holding everything backed up for one gist.
+func gistDir(dest string, gist api.GitHubGist) string {
+	return filepath.Join(dest, "gists", gist.DirName())
+}
+
+// migrateGistDir renames the backup directory of a gist to its current name. Handles directories named after the
+// gist's first file name (older versions of this program, verified by the remote URL) and directories with an outdated
+// slug (description was changed).
+func migrateGistDir(dest string, gist api.GitHubGist) error {
+	log := config.GetLogger().WithField("gist", gist.ID)
+	dir := gistDir(dest, gist)
+	if _, err := os.Stat(dir); err == nil {
+		return nil
+	}
+
+	// Look for an old directory.
+	var oldDir string
+	matches, _ := filepath.Glob(filepath.Join(dest, "gists", gist.ID+"-*"))
+	if _, err := os.Stat(filepath.Join(dest, "gists", gist.ID)); err == nil {
+		oldDir = filepath.Join(dest, "gists", gist.ID)
+	} else if len(matches) > 0 {
+		oldDir = matches[0]
+	} else {
+		legacyDir := filepath.Join(dest, "gists", gist.Name)
+		legacyClone := filepath.Join(legacyDir, gist.Name+".git")
+		if _, err := os.Stat(legacyClone); err != nil {
+			return nil // Nothing to migrate.
+		}
+		if url, err := clone.RemoteURL(legacyClone); err != nil || url != gist.CloneURL {
+			return nil // Belongs to a different gist with the same file name.
+		}
+		if err := os.Rename(legacyClone, filepath.Join(legacyDir, gist.ID+".git")); err != nil {
+			return err
+		}
+		oldDir = legacyDir
+	}
+
+	// Rename.
+	log.WithField("from", oldDir).WithField("to", dir).Info("Migrating gist backup directory.")
+	return os.Rename(oldDir, dir)
+}
+
+func newCounts() map[string]int {
+	return map[string]int{
+		clone.Created: 0, clone.Updated: 0, clone.Reset: 0, clone.Skipped: 0, "unchanged": 0, "failed": 0,
+	}
+}
+
+// mirror clones or fetches one repository and records the outcome in counts. If missingOK is true a remote repository
+// that doesn't exist is counted as "missing" instead of "failed". Returns true if the local clone is up to date and
+// the error if it failed.
+func mirror(log *logrus.Entry, counts map[string]int, name, url, dir string, overwrite, missingOK bool) (bool, error) {
+	status, err := clone.Mirror(url, dir, overwrite)
+	if err == clone.ErrNotFound && missingOK {
+		counts["missing"]++
+		log.Debugf("%s: %s", name, err.Error())
+		return false, nil
+	} else if err != nil {
+		counts["failed"]++
+		log.Errorf("Failed to clone %s: %s", name, err.Error())
+		return false, err
+	}
+	counts[status]++
+	if status == clone.Skipped {
+		log.WithField("status", status).Warnf("%s: %s (directory exists but isn't a git repository)", name, status)
+		return false, nil
+	}
+	log.WithField("status", status).Infof("%s: %s", name, status)
+	return true, nil
+}
+
+// mirrorIfChanged calls mirror() unless the remote wasn't pushed to since the last backup. Updates the state.
+func mirrorIfChanged(log *logrus.Entry, counts map[string]int, state *backupState, name, url, dir string,
+	overwrite bool, pushedAt, updatedAt time.Time) error {
+	if !overwrite && state.unchanged(dir, pushedAt, updatedAt) {
+		counts["unchanged"]++
+		log.WithField("status", "unchanged").Debugf("%s: unchanged since the last backup", name)
+		return nil
+	}
+	ok, err := mirror(log, counts, name, url, dir, overwrite, false)
+	state.record(dir, pushedAt, updatedAt, ok)
+	return err
+}
+
+// outcome returns the key of the only non-zero count, for reporting what happened to one item. Empty if all are zero.
+func outcome(counts map[string]int) string {
+	for key, value := range counts {
+		if value > 0 {
+			return key
+		}
+	}
+	return ""
+}
+
+func logCounts(log *logrus.Entry, counts map[string]int, one, many string) {
+	c := counts[clone.Created]
+	u := counts[clone.Updated] + counts[clone.Reset]
+	s := counts[clone.Skipped]
+	msg := "Cloned %d new %s, updated %d existing and skipped %d."
+	log.WithFields(toFields(counts)).Infof(msg, c, plural(c, one, many), u, s)
+	if n := counts["unchanged"]; n > 0 {
+		log.Infof("--> %d %s unchanged since the last backup, not fetched.", n, plural(n, one+" was", many+" were"))
+	}
+}
+
+// parallel calls do for every index from 0 to n-1 using up to jobs goroutines. Returns when all calls returned.
+func parallel(jobs, n int, do func(i int)) {
+	if jobs < 1 {
+		jobs = 1
+	}
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < jobs && w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				do(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
+
+// addCounts adds the values of counts to total.
+func addCounts(total, counts map[string]int) {
+	for key, value := range counts {
+		total[key] += value
+	}
+}
+
+// repoCounts tallies the outcomes of backing up repos. Each worker fills its own and they're added up afterwards.
+type repoCounts struct {
+	repos, wikis, metadata, issues, pulls, assets map[string]int
+}
+
+func newRepoCounts() repoCounts {
+	counts := repoCounts{
+		repos:    newCounts(),
+		wikis:    newCounts(),
+		metadata: map[string]int{"exported": 0, "failed": 0},
+		issues:   map[string]int{"exported": 0, "skipped": 0, "failed": 0},
+		pulls:    map[string]int{"exported": 0, "skipped": 0, "failed": 0},
+		assets:   map[string]int{"downloaded": 0, "skipped": 0, "failed": 0},
+	}
+	counts.wikis["missing"] = 0
+	return counts
+}
+
+func (c repoCounts) add(other repoCounts) {
+	addCounts(c.repos, other.repos)
+	addCounts(c.wikis, other.wikis)
+	addCounts(c.metadata, other.metadata)
+	addCounts(c.issues, other.issues)
+	addCounts(c.pulls, other.pulls)
+	addCounts(c.assets, other.assets)
+}
+
+// backupRepo mirror-clones one repository and its wiki, exports its settings, GitHub Issues and pull requests and
+// downloads its releases.
+func backupRepo(cfg *config.Config, ghAPI *api.API, repo api.GitHubRepo, state *backupState,
+	counts repoCounts) reportItem {
+	fullName := repo.Name
+	if repo.Owner != "" {
+		fullName = repo.Owner + "/" + repo.Name
+	}
+	logRepo := config.GetLogger().WithField("repo", fullName)
+	dir := repoDir(cfg.Destination, repo)
+	item := reportItem{Name: fullName, Dir: relDir(cfg.Destination, dir)}
+	fail := func(err error) {
+		item.Errors = append(item.Errors, err.Error())
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		logRepo.Errorf("Failed creating directory: %s", err.Error())
+		counts.repos["failed"]++
+		fail(err)
+		item.Status = "failed"
+		return item
+	}
+	size := dirSize(dir)
+	cloneDir := filepath.Join(dir, repo.Name+".git")
+	if err := mirrorIfChanged(logRepo, counts.repos, state, repo.Name, repo.CloneURL, cloneDir, cfg.Overwrite,
+		repo.PushedAt, repo.UpdatedAt); err != nil {
+		fail(err)
+	}
+
+	// Wikis are enabled by default on GitHub but the git repo only exists after the first page is created.
+	// Pushes to wikis don't change PushedAt so they're always fetched.
+	if repo.WikiURL != "" {
+		name := repo.Name + ".wiki"
+		wikiDir := filepath.Join(dir, name+".git")
+		if _, err := mirror(logRepo, counts.wikis, name, repo.WikiURL, wikiDir, cfg.Overwrite, true); err != nil {
+			fail(err)
+		}
+	}
+
+	// Settings, collaborators, branch protection, etc.
+	if !cfg.NoMetadata && !repo.Starred {
+		if err := ghAPI.ExportMetadata(&repo, dir); err != nil {
+			logRepo.Errorf("Failed to export metadata: %s", err.Error())
+			counts.metadata["failed"]++
+			fail(err)
+		} else {
+			counts.metadata["exported"]++
+		}
+	}
+
+	// Issues.
+	if repo.HasIssues {
+		if skipped, err := ghAPI.ExportIssues(&repo, dir); err != nil {
+			logRepo.Errorf("Failed to export issues: %s", err.Error())
+			counts.issues["failed"]++
+			fail(err)
+		} else if skipped {
+			counts.issues["skipped"]++
+		} else {
+			counts.issues["exported"]++
+		}
+	}
+
+	// Pull requests.
+	if !cfg.NoPulls && !repo.Starred {
+		exported, skipped, err := ghAPI.ExportPulls(&repo, dir)
+		counts.pulls["exported"] += exported
+		counts.pulls["skipped"] += skipped
+		if err != nil {
+			logRepo.Errorf("Failed to export pull requests: %s", err.Error())
+			counts.pulls["failed"]++
+			fail(err)
+		}
+	}
+
+	// Releases.
+	if !cfg.NoReleases && !repo.Starred {
+		downloaded, skipped, failed, err := ghAPI.ExportReleases(&repo, dir)
+		counts.assets["downloaded"] += downloaded
+		counts.assets["skipped"] += skipped
+		counts.assets["failed"] += failed
+		if err != nil {
+			logRepo.Errorf("Failed to backup releases: %s", err.Error())
+			if failed == 0 {
+				counts.assets["failed"]++ // Releases couldn't be listed.
+			}
+			fail(err)
+		}
+	}
+
+	// Report.
+	item.Status = outcome(counts.repos)
+	item.Wiki = outcome(counts.wikis)
+	item.Metadata = outcome(counts.metadata)
+	item.Issues = outcome(counts.issues)
+	item.Pulls = counts.pulls["exported"]
+	item.Assets = counts.assets["downloaded"]
+	if grown := dirSize(dir) - size; grown > 0 {
+		item.Bytes = grown
+	}
+	return item
+}
+
+// backupRepos backs up repositories, up to cfg.Jobs at a time. Returns the number of failures and the outcome of each
+// repository.
+//
+// :param starred: ghRepos holds starred repos (logged as such, without releases).
+func backupRepos(cfg *config.Config, ghAPI *api.API, ghRepos *api.GitHubRepos, state *backupState,
+	starred bool) (int, []reportItem) {
+	log := config.GetLogger()
+	counts := newRepoCounts()
+	items := make([]reportItem, len(*ghRepos))
+	var mu sync.Mutex
+	parallel(cfg.Jobs, len(*ghRepos), func(i int) {
+		repoCounts := newRepoCounts()
+		items[i] = backupRepo(cfg, ghAPI, (*ghRepos)[i], state, repoCounts)
+		mu.Lock()
+		counts.add(repoCounts)
+		mu.Unlock()
+	})
+
+	if starred {
+		logCounts(log, counts.repos, "starred repo", "starred repos")
+	} else {
+		logCounts(log, counts.repos, "repo", "repos")
+	}
+	if ghRepos.Counts()["wikis"] > 0 {
+		logCounts(log, counts.wikis, "wiki", "wikis")
+		if m := counts.wikis["missing"]; m > 0 {
+			log.Infof("--> %d wiki%s enabled but never created.", m, plural(m, " was", "s were"))
+		}
+	}
+	if !cfg.NoMetadata && !starred {
+		e := counts.metadata["exported"]
+		log.WithFields(toFields(counts.metadata)).Infof("Exported the metadata of %d repo%s.", e, plural(e, "", "s"))
+	}
+	if ghRepos.Counts()["issues"] > 0 {
+		e := counts.issues["exported"]
+		msg := "Exported GitHub Issues of %d repo%s (%d unchanged since the last backup)."
+		log.WithFields(toFields(counts.issues)).Infof(msg, e, plural(e, "", "s"), counts.issues["skipped"])
+	}
+	if !cfg.NoPulls && !starred {
+		e := counts.pulls["exported"]
+		msg := "Exported %d pull request%s (%d unchanged since the last backup)."
+		log.WithFields(toFields(counts.pulls)).Infof(msg, e, plural(e, "", "s"), counts.pulls["skipped"])
+	}
+	if !cfg.NoReleases && !starred {
+		d := counts.assets["downloaded"]
+		msg := "Downloaded %d release asset%s (%d already downloaded)."
+		log.WithFields(toFields(counts.assets)).Infof(msg, d, plural(d, "", "s"), counts.assets["skipped"])
+	}
+	failed := counts.repos["failed"] + counts.wikis["failed"] + counts.metadata["failed"] + counts.issues["failed"] +
+		counts.pulls["failed"] + counts.assets["failed"]
+	return failed, items
+}
+
+// gistCounts tallies the outcomes of backing up gists like repoCounts.
+type gistCounts struct {
+	gists, comments map[string]int
+}
+
+func newGistCounts() gistCounts {
+	return gistCounts{gists: newCounts(), comments: map[string]int{"saved": 0, "failed": 0}}
+}
+
+func (c gistCounts) add(other gistCounts) {
+	addCounts(c.gists, other.gists)
+	addCounts(c.comments, other.comments)
+}
+
+// backupGist mirror-clones one gist and saves its comments.
+func backupGist(cfg *config.Config, ghAPI *api.API, gist api.GitHubGist, state *backupState,
+	counts gistCounts) reportItem {
+	logGist := config.GetLogger().WithField("gist", gist.ID)
+	dir := gistDir(cfg.Destination, gist)
+	item := reportItem{Name: gist.ID, Dir: relDir(cfg.Destination, dir)}
+	fail := func(err error) {
+		item.Errors = append(item.Errors, err.Error())
+	}
+	if err := migrateGistDir(cfg.Destination, gist); err != nil {
+		logGist.Errorf("Failed migrating old gist directory: %s", err.Error())
+		counts.gists["failed"]++
+		fail(err)
+		item.Status = "failed"
+		return item
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		logGist.Errorf("Failed creating directory: %s", err.Error())
+		counts.gists["failed"]++
+		fail(err)
+		item.Status = "failed"
+		return item
+	}
+	size := dirSize(dir)
+	cloneDir := filepath.Join(dir, gist.ID+".git")
+	name, url := gist.DirName(), gist.CloneURL
+	if err := mirrorIfChanged(logGist, counts.gists, state, name, url, cloneDir, cfg.Overwrite, gist.PushedAt,
+		gist.PushedAt); err != nil {
+		fail(err)
+	}
+
+	// Comments.
+	if gist.HasComments {
+		if err := ghAPI.ExportGistComments(&gist, dir); err != nil {
+			logGist.Errorf("Failed to save gist comments: %s", err.Error())
+			counts.comments["failed"]++
+			fail(err)
+		} else {
+			counts.comments["saved"]++
+		}
+	}
+
+	// Report.
+	item.Status = outcome(counts.gists)
+	item.Comments = outcome(counts.comments)
+	if grown := dirSize(dir) - size; grown > 0 {
+		item.Bytes = grown
+	}
+	return item
+}
+
+// backupGists backs up gists, up to cfg.Jobs at a time. Returns the number of failures and the outcome of each gist.
+func backupGists(cfg *config.Config, ghAPI *api.API, ghGists *api.GitHubGists,
+	state *backupState) (int, []reportItem) {
+	log := config.GetLogger()
+	counts := newGistCounts()
+	items := make([]reportItem, len(*ghGists))
+	var mu sync.Mutex
+	parallel(cfg.Jobs, len(*ghGists), func(i int) {
+		gistCounts := newGistCounts()
+		items[i] = backupGist(cfg, ghAPI, (*ghGists)[i], state, gistCounts)
+		mu.Lock()
+		counts.add(gistCounts)
+		mu.Unlock()
+	})
+
+	logCounts(log, counts.gists, "gist", "gists")
+	if ghGists.Counts()["comments"] > 0 {
+		c := counts.comments["saved"]
+		log.WithFields(toFields(counts.comments)).Infof("Saved comments of %d gist%s.", c, plural(c, "", "s"))
+	}
+	return counts.gists["failed"] + counts.comments["failed"], items
+}
+
+// Backup mirror-clones every collected repository, wiki, starred repository and gist into the destination directory.
+// Also exports GitHub Issues, releases and gist comments. The outcome of each repo and gist is added to the report.
+func Backup(cfg *config.Config, ghAPI *api.API, ghRepos, ghStarred *api.GitHubRepos, ghGists *api.GitHubGists,
+	report *Report) error {
+	var failed, n int
+	state := loadState(cfg.Destination)
+	if len(*ghRepos) > 0 {
+		n, report.Repos = backupRepos(cfg, ghAPI, ghRepos, state, false)
+		failed += n
+	}
+	if len(*ghStarred) > 0 {
+		n, report.Starred = backupRepos(cfg, ghAPI, ghStarred, state, true)
+		failed += n
+	}
+	if len(*ghGists) > 0 {
+		n, report.Gists = backupGists(cfg, ghAPI, ghGists, state)
+		failed += n
+	}
+	if err := state.save(); err != nil {
+		config.GetLogger().Errorf("Failed to save state file: %s", err.Error())
+		failed++
+	}
+	if failed > 0 {
+		config.GetLogger().Errorf("Failed to backup %d item%s. See errors above.", failed, plural(failed, "", "s"))
+		return errors.New("failed to backup one or more repos or gists")
+	}
+	return nil
+}
@@ -0,0 +1,466 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Robpol86/githubBackup/api"
+	"github.com/Robpol86/githubBackup/config"
+	"github.com/Robpol86/githubBackup/testUtils"
+	"github.com/Sirupsen/logrus"
+	"github.com/Sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/require"
+)
+
+func git(assert *require.Assertions, dir string, args ...string) string {
+	args = append([]string{"-c", "user.name=Test", "-c", "user.email=test@example.com"}, args...)
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	output, err := cmd.CombinedOutput()
+	assert.NoError(err, string(output))
+	return strings.TrimSpace(string(output))
+}
+
+func newSource(assert *require.Assertions, dir string) string {
+	assert.NoError(os.MkdirAll(dir, os.ModePerm))
+	git(assert, dir, "init", "-q")
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "file.txt"), []byte("content"), 0644))
+	git(assert, dir, "add", "file.txt")
+	git(assert, dir, "commit", "-q", "-m", "first")
+	return dir
+}
+
+// lastStatus returns the last log entry about the outcome of a clone.
+func lastStatus(logs *test.Hook) *logrus.Entry {
+	for i := len(logs.Entries) - 1; i >= 0; i-- {
+		if _, ok := logs.Entries[i].Data["status"]; ok {
+			return logs.Entries[i]
+		}
+	}
+	return nil
+}
+
+func TestBackup(t *testing.T) {
+	assert := require.New(t)
+
+	tmpdir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpdir)
+	dest := filepath.Join(tmpdir, "dest")
+	assert.NoError(os.Mkdir(dest, os.ModePerm))
+
+	cfg := config.Config{Destination: dest, NoReleases: true, NoPulls: true, NoMetadata: true}
+	ghRepos := api.GitHubRepos{
+		{
+			Name:     "one",
+			CloneURL: newSource(assert, filepath.Join(tmpdir, "one")),
+			WikiURL:  newSource(assert, filepath.Join(tmpdir, "one.wiki")),
+		},
+		{Name: "two", CloneURL: newSource(assert, filepath.Join(tmpdir, "two"))},
+	}
+
+	// First run clones.
+	logs, stdout, stderr, err := testUtils.WithLogging(func() {
+		assert.NoError(Backup(&cfg, &api.API{}, &ghRepos, &api.GitHubRepos{}, &api.GitHubGists{}, &Report{}))
+	})
+	assert.NoError(err)
+	assert.Empty(stdout)
+	assert.Empty(stderr)
+	assert.Equal("Cloned 2 new repos, updated 0 existing and skipped 0.", logs.Entries[len(logs.Entries)-2].Message)
+	assert.Equal("Cloned 1 new wiki, updated 0 existing and skipped 0.", logs.LastEntry().Message)
+	for _, name := range []string{"one", "two", "one.wiki"} {
+		actual := git(assert, filepath.Join(dest, strings.Split(name, ".")[0], name+".git"), "rev-parse", "HEAD")
+		assert.Equal(git(assert, filepath.Join(tmpdir, name), "rev-parse", "HEAD"), actual)
+	}
+
+	// Second run fetches repos pushed to since the first run.
+	for i := range ghRepos {
+		ghRepos[i].PushedAt = time.Now()
+	}
+	logs, _, _, err = testUtils.WithLogging(func() {
+		assert.NoError(Backup(&cfg, &api.API{}, &ghRepos, &api.GitHubRepos{}, &api.GitHubGists{}, &Report{}))
+	})
+	assert.NoError(err)
+	assert.Equal("Cloned 0 new repos, updated 2 existing and skipped 0.", logs.Entries[len(logs.Entries)-2].Message)
+	assert.Equal("Cloned 0 new wikis, updated 1 existing and skipped 0.", logs.LastEntry().Message)
+	assert.Equal("updated", lastStatus(logs).Data["status"])
+
+	// Third run resets.
+	cfg.Overwrite = true
+	logs, _, _, err = testUtils.WithLogging(func() {
+		assert.NoError(Backup(&cfg, &api.API{}, &ghRepos, &api.GitHubRepos{}, &api.GitHubGists{}, &Report{}))
+	})
+	assert.NoError(err)
+	assert.Equal("Cloned 0 new repos, updated 2 existing and skipped 0.", logs.Entries[len(logs.Entries)-2].Message)
+	assert.Equal("reset", lastStatus(logs).Data["status"])
+
+	// Not a git repository.
+	assert.NoError(os.RemoveAll(filepath.Join(dest, "two", "two.git")))
+	assert.NoError(os.MkdirAll(filepath.Join(dest, "two", "two.git", "unrelated"), os.ModePerm))
+	logs, _, stderr, err = testUtils.WithLogging(func() {
+		assert.NoError(Backup(&cfg, &api.API{}, &ghRepos, &api.GitHubRepos{}, &api.GitHubGists{}, &Report{}))
+	})
+	assert.NoError(err)
+	assert.Empty(stderr)
+	assert.Equal("Cloned 0 new repos, updated 1 existing and skipped 1.", logs.Entries[len(logs.Entries)-2].Message)
+	assert.Equal("two: skipped (directory exists but isn't a git repository)", lastStatus(logs).Message)
+}
+
+func TestBackupStarred(t *testing.T) {
+	assert := require.New(t)
+
+	tmpdir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpdir)
+	dest := filepath.Join(tmpdir, "dest")
+	assert.NoError(os.Mkdir(dest, os.ModePerm))
+
+	// Releases are never exported for starred repos (the empty api.API would fail to query them).
+	cfg := config.Config{Destination: dest}
+	ghStarred := api.GitHubRepos{
+		{Name: "lib", Owner: "upstream", Starred: true, CloneURL: newSource(assert, filepath.Join(tmpdir, "lib"))},
+	}
+	report := &Report{}
+	logs, _, _, err := testUtils.WithLogging(func() {
+		assert.NoError(Backup(&cfg, &api.API{}, &api.GitHubRepos{}, &ghStarred, &api.GitHubGists{}, report))
+	})
+	assert.NoError(err)
+	assert.Equal("Cloned 1 new starred repo, updated 0 existing and skipped 0.", logs.LastEntry().Message)
+	cloneDir := filepath.Join(dest, "starred", "upstream", "lib", "lib.git")
+	expected := git(assert, filepath.Join(tmpdir, "lib"), "rev-parse", "HEAD")
+	assert.Equal(expected, git(assert, cloneDir, "rev-parse", "HEAD"))
+	assert.Empty(report.Repos)
+	assert.Len(report.Starred, 1)
+	assert.Equal(filepath.Join("starred", "upstream", "lib"), report.Starred[0].Dir)
+	assert.Equal("created", report.Starred[0].Status)
+}
+
+func TestBackupFail(t *testing.T) {
+	assert := require.New(t)
+
+	tmpdir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpdir)
+
+	cfg := config.Config{Destination: tmpdir, NoReleases: true, NoPulls: true, NoMetadata: true}
+	ghRepos := api.GitHubRepos{
+		{Name: "good", CloneURL: newSource(assert, filepath.Join(tmpdir, "source"))},
+		{Name: "bad", CloneURL: filepath.Join(tmpdir, "dne")},
+	}
+
+	logs, _, stderr, err := testUtils.WithLogging(func() {
+		err := Backup(&cfg, &api.API{}, &ghRepos, &api.GitHubRepos{}, &api.GitHubGists{}, &Report{})
+		assert.EqualError(err, "failed to backup one or more repos or gists")
+	})
+	assert.NoError(err)
+	assert.Empty(stderr)
+	assert.Equal("Failed to backup 1 item. See errors above.", logs.LastEntry().Message)
+	assert.Equal("Cloned 1 new repo, updated 0 existing and skipped 0.", logs.Entries[len(logs.Entries)-2].Message)
+}
+
+func TestBackupIssuesReleases(t *testing.T) {
+	assert := require.New(t)
+
+	tmpdir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpdir)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/repos/me/one" {
+			w.Write([]byte(`{"name": "one", "permissions": {"admin": false, "push": false, "pull": true}}`))
+		} else {
+			w.Write([]byte("[]"))
+		}
+	}))
+	defer ts.Close()
+
+	cfg := config.Config{Destination: tmpdir}
+	ghAPI := api.API{TestURL: ts.URL}
+	ghRepos := api.GitHubRepos{
+		{Name: "one", Owner: "me", CloneURL: newSource(assert, filepath.Join(tmpdir, "source")), HasIssues: true},
+	}
+
+	for _, expected := range []string{"(0 unchanged since the last backup)", "(1 unchanged since the last backup)"} {
+		logs, _, _, err := testUtils.WithLogging(func() {
+			assert.NoError(Backup(&cfg, &ghAPI, &ghRepos, &api.GitHubRepos{}, &api.GitHubGists{}, &Report{}))
+		})
+		assert.NoError(err)
+		assert.Equal("Exported the metadata of 1 repo.", logs.Entries[len(logs.Entries)-4].Message)
+		assert.Contains(logs.Entries[len(logs.Entries)-3].Message, "Exported GitHub Issues of ")
+		assert.Contains(logs.Entries[len(logs.Entries)-3].Message, expected)
+		msg := "Exported 0 pull requests (0 unchanged since the last backup)."
+		assert.Equal(msg, logs.Entries[len(logs.Entries)-2].Message)
+		assert.Equal("Downloaded 0 release assets (0 already downloaded).", logs.LastEntry().Message)
+		for _, file := range []string{api.MetadataFile, api.IssuesFile, api.ReleasesFile} {
+			_, err = os.Stat(filepath.Join(tmpdir, "one", file))
+			assert.NoError(err)
+		}
+	}
+}
+
+func TestBackupGists(t *testing.T) {
+	assert := require.New(t)
+
+	tmpdir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpdir)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal("/gists/abc123/comments", r.URL.Path)
+		w.Write([]byte(`[{"id": 1, "body": "Nice."}]`))
+	}))
+	defer ts.Close()
+
+	cfg := config.Config{Destination: filepath.Join(tmpdir, "dest")}
+	ghAPI := api.API{TestURL: ts.URL}
+	ghGists := api.GitHubGists{
+		{
+			ID: "abc123", Name: "gistfile1.txt", Slug: "gistfile1-txt", HasComments: true,
+			CloneURL: newSource(assert, filepath.Join(tmpdir, "a")),
+		},
+		{ID: "def456", Name: "notes.md", CloneURL: newSource(assert, filepath.Join(tmpdir, "b"))},
+	}
+
+	logs, stdout, stderr, err := testUtils.WithLogging(func() {
+		assert.NoError(Backup(&cfg, &ghAPI, &api.GitHubRepos{}, &api.GitHubRepos{}, &ghGists, &Report{}))
+	})
+	assert.NoError(err)
+	assert.Empty(stdout)
+	assert.Empty(stderr)
+	assert.Equal("Cloned 2 new gists, updated 0 existing and skipped 0.", logs.Entries[len(logs.Entries)-2].Message)
+	assert.Equal("Saved comments of 1 gist.", logs.LastEntry().Message)
+
+	// Verify files.
+	for _, gist := range ghGists {
+		dir := filepath.Join(cfg.Destination, "gists", gist.DirName())
+		actual := git(assert, filepath.Join(dir, gist.ID+".git"), "rev-parse", "HEAD")
+		assert.Equal(git(assert, gist.CloneURL, "rev-parse", "HEAD"), actual)
+		_, err = os.Stat(filepath.Join(dir, api.GistCommentsFile))
+		assert.Equal(gist.HasComments, err == nil)
+	}
+}
+
+func TestMigrateGistDir(t *testing.T) {
+	for _, mode := range []string{"legacy", "legacy other gist", "slug changed", "no slug", "current"} {
+		t.Run(mode, func(t *testing.T) {
+			assert := require.New(t)
+
+			tmpdir, err := ioutil.TempDir("", "")
+			assert.NoError(err)
+			defer os.RemoveAll(tmpdir)
+			dest := filepath.Join(tmpdir, "dest")
+			source := newSource(assert, filepath.Join(tmpdir, "source"))
+			gist := api.GitHubGist{ID: "abc123", Name: "README.md", Slug: "notes", CloneURL: source}
+
+			// Create old directory.
+			var oldDir, oldClone string
+			switch mode {
+			case "legacy", "legacy other gist":
+				oldDir = filepath.Join(dest, "gists", "README.md")
+				oldClone = filepath.Join(oldDir, "README.md.git")
+			case "slug changed":
+				oldDir = filepath.Join(dest, "gists", "abc123-old-description")
+				oldClone = filepath.Join(oldDir, "abc123.git")
+			case "no slug":
+				oldDir = filepath.Join(dest, "gists", "abc123")
+				oldClone = filepath.Join(oldDir, "abc123.git")
+			default:
+				oldDir = filepath.Join(dest, "gists", "abc123-notes")
+				oldClone = filepath.Join(oldDir, "abc123.git")
+			}
+			assert.NoError(os.MkdirAll(oldDir, os.ModePerm))
+			git(assert, tmpdir, "clone", "-q", "--mirror", source, oldClone)
+			if mode == "legacy other gist" {
+				gist.CloneURL = "https://gist.github.com/def456.git"
+			}
+
+			// Run.
+			logs, _, _, err := testUtils.WithLogging(func() {
+				assert.NoError(migrateGistDir(dest, gist))
+			})
+			assert.NoError(err)
+
+			// Verify.
+			_, err = os.Stat(filepath.Join(dest, "gists", "abc123-notes", "abc123.git"))
+			if mode == "legacy other gist" {
+				assert.True(os.IsNotExist(err))
+				_, err = os.Stat(oldClone)
+				assert.NoError(err)
+				return
+			}
+			assert.NoError(err)
+			if mode == "current" {
+				assert.Empty(logs.Entries)
+			} else {
+				assert.Equal("Migrating gist backup directory.", logs.LastEntry().Message)
+				_, err = os.Stat(oldDir)
+				assert.True(os.IsNotExist(err))
+			}
+		})
+	}
+}
+
+func TestRepoDir(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Equal(filepath.Join("dest", "name"), repoDir("dest", api.GitHubRepo{Name: "name", Owner: "me"}))
+	actual := repoDir("dest", api.GitHubRepo{Name: "name", Owner: "org", Org: "org"})
+	assert.Equal(filepath.Join("dest", "orgs", "org", "name"), actual)
+	actual = repoDir("dest", api.GitHubRepo{Name: "name", Owner: "friend", Shared: true})
+	assert.Equal(filepath.Join("dest", "users", "friend", "name"), actual)
+	actual = repoDir("dest", api.GitHubRepo{Name: "name", Owner: "upstream", Starred: true})
+	assert.Equal(filepath.Join("dest", "starred", "upstream", "name"), actual)
+}
+
+func TestBackupUnchanged(t *testing.T) {
+	assert := require.New(t)
+
+	tmpdir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpdir)
+	dest := filepath.Join(tmpdir, "dest")
+	assert.NoError(os.Mkdir(dest, os.ModePerm))
+
+	pushedAt := time.Date(2016, 10, 20, 0, 0, 0, 0, time.UTC)
+	cfg := config.Config{Destination: dest, NoReleases: true, NoPulls: true, NoMetadata: true}
+	ghRepos := api.GitHubRepos{
+		{Name: "one", CloneURL: newSource(assert, filepath.Join(tmpdir, "one")), PushedAt: pushedAt},
+		{Name: "two", CloneURL: newSource(assert, filepath.Join(tmpdir, "two")), PushedAt: pushedAt},
+	}
+	gistURL := newSource(assert, filepath.Join(tmpdir, "g"))
+	ghGists := api.GitHubGists{{ID: "abc123", CloneURL: gistURL, PushedAt: pushedAt}}
+	run := func() []string {
+		logs, _, _, err := testUtils.WithLogging(func() {
+			assert.NoError(Backup(&cfg, &api.API{}, &ghRepos, &api.GitHubRepos{}, &ghGists, &Report{}))
+		})
+		assert.NoError(err)
+		var messages []string
+		for _, entry := range logs.Entries {
+			if entry.Level <= logrus.InfoLevel {
+				messages = append(messages, entry.Message)
+			}
+		}
+		return messages
+	}
+
+	// First run clones and saves state.
+	run()
+	state := loadState(dest)
+	assert.Len(state.Clones, 3)
+	expected := git(assert, filepath.Join(tmpdir, "one"), "rev-parse", "HEAD")
+	assert.Equal(itemState{PushedAt: pushedAt, Head: expected}, state.Clones["one/one.git"])
+	assert.Contains(state.Clones, "gists/abc123/abc123.git")
+
+	// Nothing changed.
+	messages := run()
+	assert.Contains(messages, "Cloned 0 new repos, updated 0 existing and skipped 0.")
+	assert.Contains(messages, "--> 2 repos were unchanged since the last backup, not fetched.")
+	assert.Contains(messages, "--> 1 gist was unchanged since the last backup, not fetched.")
+
+	// One repo pushed to, one local clone not at the recorded commit.
+	ghRepos[0].PushedAt = pushedAt.Add(time.Hour)
+	state = loadState(dest)
+	state.Clones["two/two.git"] = itemState{PushedAt: pushedAt, Head: "0123456789abcdef0123456789abcdef01234567"}
+	assert.NoError(state.save())
+	messages = run()
+	assert.Contains(messages, "Cloned 0 new repos, updated 2 existing and skipped 0.")
+	assert.Equal(ghRepos[0].PushedAt, loadState(dest).Clones["one/one.git"].PushedAt)
+
+	// Overwrite always fetches.
+	cfg.Overwrite = true
+	messages = run()
+	assert.Contains(messages, "Cloned 0 new repos, updated 2 existing and skipped 0.")
+}
+
+func TestParallel(t *testing.T) {
+	assert := require.New(t)
+
+	for _, jobs := range []int{0, 1, 3, 20} {
+		var mu sync.Mutex
+		var running, maxRunning int
+		seen := map[int]int{}
+		parallel(jobs, 10, func(i int) {
+			mu.Lock()
+			seen[i]++
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			mu.Unlock()
+			time.Sleep(time.Millisecond)
+			mu.Lock()
+			running--
+			mu.Unlock()
+		})
+		assert.Len(seen, 10)
+		for i := 0; i < 10; i++ {
+			assert.Equal(1, seen[i])
+		}
+		if jobs < 1 {
+			jobs = 1
+		}
+		assert.True(maxRunning <= jobs, "%d > %d", maxRunning, jobs)
+	}
+}
+
+func TestBackupJobs(t *testing.T) {
+	assert := require.New(t)
+
+	tmpdir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpdir)
+	dest := filepath.Join(tmpdir, "dest")
+	assert.NoError(os.Mkdir(dest, os.ModePerm))
+
+	cfg := config.Config{Destination: dest, Jobs: 4, NoReleases: true, NoPulls: true, NoMetadata: true}
+	var ghRepos api.GitHubRepos
+	for _, name := range []string{"a", "b", "c", "d", "e", "f"} {
+		ghRepos = append(ghRepos, api.GitHubRepo{Name: name, CloneURL: newSource(assert, filepath.Join(tmpdir, name))})
+	}
+	ghRepos = append(ghRepos, api.GitHubRepo{Name: "bad", CloneURL: filepath.Join(tmpdir, "dne")})
+	var ghGists api.GitHubGists
+	for _, id := range []string{"g1", "g2", "g3"} {
+		ghGists = append(ghGists, api.GitHubGist{ID: id, CloneURL: newSource(assert, filepath.Join(tmpdir, id))})
+	}
+
+	report := &Report{}
+	logs, _, _, err := testUtils.WithLogging(func() {
+		assert.Error(Backup(&cfg, &api.API{}, &ghRepos, &api.GitHubRepos{}, &ghGists, report))
+	})
+	assert.NoError(err)
+	var messages []string
+	tagged := map[string]bool{}
+	for _, entry := range logs.Entries {
+		messages = append(messages, entry.Message)
+		if name, ok := entry.Data["repo"]; ok {
+			tagged[name.(string)] = true
+		}
+	}
+	assert.Contains(messages, "Cloned 6 new repos, updated 0 existing and skipped 0.")
+	assert.Contains(messages, "Cloned 3 new gists, updated 0 existing and skipped 0.")
+	assert.Equal("Failed to backup 1 item. See errors above.", logs.LastEntry().Message)
+	assert.Len(tagged, 7)
+	assert.Len(loadState(dest).Clones, 9)
+
+	// Report keeps the order of the repos and gists.
+	assert.Len(report.Repos, 7)
+	assert.Len(report.Gists, 3)
+	assert.Equal("a", report.Repos[0].Name)
+	assert.Equal("a", report.Repos[0].Dir)
+	assert.Equal("created", report.Repos[0].Status)
+	assert.True(report.Repos[0].Bytes > 0)
+	assert.Empty(report.Repos[0].Errors)
+	assert.Equal("bad", report.Repos[6].Name)
+	assert.Equal("failed", report.Repos[6].Status)
+	assert.Len(report.Repos[6].Errors, 1)
+	assert.Equal("g3", report.Gists[2].Name)
+	assert.Equal("gists/g3", report.Gists[2].Dir)
+}
@@ -0,0 +1,222 @@
+package clone
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/Robpol86/githubBackup/config"
+)
+
+// Possible statuses returned by Mirror().
+const (
+	Created = "created"
+	Updated = "updated"
+	Reset   = "reset"
+	Skipped = "skipped"
+)
+
+// ErrNotFound is returned by Mirror() when the remote repository doesn't exist (e.g. a wiki that was never created).
+var ErrNotFound = errors.New("repository not found")
+
+var reNotFound = regexp.MustCompile(`(?i)repository not found|repository '[^']*' not found`)
+
+// tokenEnv is the environment variable passing the token to the credential helper, keeping it out of command lines.
+const tokenEnv = "GITHUB_BACKUP_TOKEN"
+
+// credentialHelper answers git's HTTPS credential requests with the token. Any user name works with GitHub tokens.
+const credentialHelper = `!f() { test "$1" = get && echo username=x-access-token && echo "password=$` + tokenEnv +
+	`"; }; f`
+
+// Auth holds credentials used by git to clone and fetch.
+type Auth struct {
+	Token      string // GitHub personal access token for HTTPS remotes. Not used if empty.
+	SSHKey     string // Private key file for SSH remotes. ssh picks its default keys if empty.
+	KnownHosts string // known_hosts file for SSH remotes. ssh uses its default files if empty.
+}
+
+var auth Auth
+
+// SetAuth sets the credentials used by Mirror() from now on. The token is never written to .git/config or logs.
+func SetAuth(a Auth) {
+	auth = a
+}
+
+// shellQuote quotes a string for the shell interpreting GIT_SSH_COMMAND.
+func shellQuote(value string) string {
+	return "'" + strings.Replace(value, "'", `'\''`, -1) + "'"
+}
+
+// authArgs returns the git options (before the subcommand so they're not saved into the repo's config) and the
+// environment variables passing credentials to remote operations.
+func authArgs() (args []string, env []string) {
+	if auth.Token != "" {
+		// Clear other helpers first so they're not asked (and don't store the token).
+		args = append(args, "-c", "credential.helper=", "-c", "credential.helper="+credentialHelper)
+		env = append(env, tokenEnv+"="+auth.Token)
+	}
+	if auth.SSHKey != "" || auth.KnownHosts != "" {
+		command := "ssh"
+		if auth.SSHKey != "" {
+			command += " -i " + shellQuote(auth.SSHKey) + " -o IdentitiesOnly=yes"
+		}
+		if auth.KnownHosts != "" {
+			command += " -o UserKnownHostsFile=" + shellQuote(auth.KnownHosts)
+		}
+		env = append(env, "GIT_SSH_COMMAND="+command)
+	}
+	return
+}
+
+func lastLine(output string) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+func run(gitDir string, args ...string) (string, error) {
+	command := args[0]
+	if gitDir != "" {
+		args = append([]string{"--git-dir", gitDir}, args...)
+	}
+	env := append(os.Environ(), "GIT_TERMINAL_PROMPT=0") // Fail instead of hanging on a password prompt.
+	env = append(env, "LC_ALL=C")                        // Untranslated output, it's parsed below and by Mirror().
+	if command == "clone" || command == "fetch" {
+		authOptions, authEnv := authArgs()
+		args = append(authOptions, args...)
+		env = append(env, authEnv...)
+	}
+	log := config.GetLogger().WithField("args", args)
+	log.Debug("Running git.")
+
+	cmd := exec.Command("git", args...)
+	cmd.Env = env
+	outputBytes, err := cmd.CombinedOutput()
+	output := string(outputBytes)
+	log.WithField("output", output).Debug("Git exited.")
+	if err != nil {
+		if reNotFound.MatchString(output) {
+			err = ErrNotFound
+		} else if _, ok := err.(*exec.ExitError); ok && output != "" {
+			err = fmt.Errorf("git %s failed: %s", command, lastLine(output))
+		}
+		return output, err
+	}
+	return output, nil
+}
+
+// divergedReasons are what git fetch prints after refs it refused to update because the local ref diverged from the
+// remote one (force pushed branches, moved tags).
+var divergedReasons = []string{"(non-fast-forward)", "(would clobber existing tag)", "(already exists)"}
+
+// countRejected returns the number of refs git fetch refused to update because they diverged from the remote, and the
+// first line of git's output reporting any other problem (empty if there's none).
+func countRejected(output string) (count int, problem string) {
+	for _, line := range strings.Split(output, "\n") {
+		trimmed := strings.TrimSpace(line)
+		diverged := false
+		for _, reason := range divergedReasons {
+			diverged = diverged || strings.HasSuffix(trimmed, reason)
+		}
+		switch {
+		case strings.HasPrefix(trimmed, "! ") && diverged:
+			count++
+		case strings.HasPrefix(trimmed, "! "), strings.HasPrefix(trimmed, "error:"),
+			strings.HasPrefix(trimmed, "fatal:"):
+			if problem == "" {
+				problem = trimmed
+			}
+		}
+	}
+	return
+}
+
+// Mirror does a mirror clone of a remote repository so all branches and tags are cloned. If the local directory already
+// exists the remote is fetched instead. Local refs are only fast-forwarded unless overwrite is true, in which case they
+// are force updated to match the remote and refs deleted on the remote are pruned. GitHub pull request heads
+// (refs/pull/*/head) are always fetched so their commits stay around after the branches are deleted.
+//
+// Returns ErrNotFound if the remote repository doesn't exist.
+//
+// :param url: Clone URL of the remote repository.
+//
+// :param dir: Local directory to clone into (bare repository).
+//
+// :param overwrite: Force update local refs to match the remote.
+func Mirror(url, dir string, overwrite bool) (status string, err error) {
+	log := config.GetLogger().WithField("dir", dir)
+
+	if _, err = os.Stat(dir); os.IsNotExist(err) {
+		if _, err = run("", "clone", "--mirror", "--quiet", url, dir); err != nil {
+			os.RemoveAll(dir) // Don't leave a half-cloned repo behind. It would be fetched next time.
+			return
+		}
+		status = Created
+		return
+	} else if err != nil {
+		return
+	}
+
+	// Don't touch directories that aren't git repositories.
+	if _, err = run(dir, "rev-parse", "--git-dir"); err != nil {
+		log.Debugf("Not a git repository: %s", err.Error())
+		status, err = Skipped, nil
+		return
+	}
+
+	// Follow clone URL changes, e.g. a different --clone-protocol than the last backup.
+	if remote, _ := RemoteURL(dir); remote != url {
+		log.WithField("from", remote).WithField("to", url).Debug("Updating remote URL.")
+		if _, err = run(dir, "remote", "set-url", "origin", url); err != nil {
+			return
+		}
+	}
+
+	if overwrite {
+		if _, err = run(dir, "fetch", "--prune", "--force", "--quiet", "origin", "+refs/*:refs/*"); err != nil {
+			return
+		}
+		status = Reset
+		return
+	}
+
+	output, err := run(dir, "fetch", "origin", "refs/heads/*:refs/heads/*", "refs/tags/*:refs/tags/*",
+		"+refs/pull/*/head:refs/pull/*/head")
+	if rejected, problem := countRejected(output); rejected > 0 {
+		log.WithField("rejected", rejected).Warn("Some local refs diverged from the remote and were left as-is.")
+		if problem != "" {
+			err = fmt.Errorf("git fetch failed: %s", problem) // Not just diverged refs, e.g. a network error.
+		} else {
+			err = nil
+		}
+	}
+	if err != nil {
+		return
+	}
+	status = Updated
+	return
+}
+
+// RemoteURL returns the URL of the "origin" remote of a local repository.
+//
+// :param dir: Local repository directory.
+func RemoteURL(dir string) (string, error) {
+	output, err := run(dir, "config", "--get", "remote.origin.url")
+	return strings.TrimSpace(output), err
+}
+
+// Head returns the commit hash HEAD points to in a local repository.
+//
+// :param dir: Local repository directory.
+func Head(dir string) (string, error) {
+	output, err := run(dir, "rev-parse", "--verify", "--quiet", "HEAD")
+	return strings.TrimSpace(output), err
+}
+
+// Version returns the version of git, e.g. "git version 2.11.0".
+func Version() (string, error) {
+	output, err := run("", "version")
+	return strings.TrimSpace(output), err
+}
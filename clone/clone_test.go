@@ -0,0 +1,349 @@
+package clone
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Robpol86/githubBackup/testUtils"
+	"github.com/stretchr/testify/require"
+)
+
+func git(assert *require.Assertions, dir string, args ...string) string {
+	args = append([]string{"-c", "user.name=Test", "-c", "user.email=test@example.com"}, args...)
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	output, err := cmd.CombinedOutput()
+	assert.NoError(err, string(output))
+	return strings.TrimSpace(string(output))
+}
+
+func commit(assert *require.Assertions, dir, message string) string {
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "file.txt"), []byte(message), 0644))
+	git(assert, dir, "add", "file.txt")
+	git(assert, dir, "commit", "-q", "-m", message)
+	return git(assert, dir, "rev-parse", "HEAD")
+}
+
+func newSource(assert *require.Assertions, tmpdir string) string {
+	source := filepath.Join(tmpdir, "source")
+	assert.NoError(os.Mkdir(source, os.ModePerm))
+	git(assert, source, "init", "-q")
+	commit(assert, source, "first")
+	return source
+}
+
+func TestMirror(t *testing.T) {
+	assert := require.New(t)
+
+	tmpdir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpdir)
+	source := newSource(assert, tmpdir)
+	dest := filepath.Join(tmpdir, "dest.git")
+
+	// Clone.
+	var status string
+	logs, stdout, stderr, err := testUtils.WithLogging(func() {
+		status, err = Mirror(source, dest, false)
+		assert.NoError(err)
+	})
+	assert.NoError(err)
+	assert.Empty(stdout)
+	assert.Empty(stderr)
+	assert.Equal(Created, status)
+	assert.Equal("Running git.", logs.Entries[0].Message)
+	assert.Equal(git(assert, source, "rev-parse", "HEAD"), git(assert, dest, "rev-parse", "HEAD"))
+
+	// New commit and a new branch, then fetch.
+	git(assert, source, "branch", "feature")
+	head := commit(assert, source, "second")
+	status, err = Mirror(source, dest, false)
+	assert.NoError(err)
+	assert.Equal(Updated, status)
+	assert.Equal(head, git(assert, dest, "rev-parse", "HEAD"))
+	assert.Contains(git(assert, dest, "branch", "--list"), "feature")
+}
+
+func TestMirrorURLChanged(t *testing.T) {
+	assert := require.New(t)
+
+	tmpdir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpdir)
+	source := newSource(assert, tmpdir)
+	dest := filepath.Join(tmpdir, "dest.git")
+	_, err = Mirror(source, dest, false)
+	assert.NoError(err)
+
+	// Same repo at a different URL.
+	moved := filepath.Join(tmpdir, "moved")
+	assert.NoError(os.Rename(source, moved))
+	head := commit(assert, moved, "second")
+	status, err := Mirror(moved, dest, false)
+	assert.NoError(err)
+	assert.Equal(Updated, status)
+	assert.Equal(head, git(assert, dest, "rev-parse", "HEAD"))
+	url, err := RemoteURL(dest)
+	assert.NoError(err)
+	assert.Equal(moved, url)
+}
+
+func TestMirrorPullRefs(t *testing.T) {
+	assert := require.New(t)
+
+	tmpdir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpdir)
+	source := newSource(assert, tmpdir)
+	dest := filepath.Join(tmpdir, "dest.git")
+	_, err = Mirror(source, dest, false)
+	assert.NoError(err)
+
+	// A pull request from a branch that's deleted afterwards.
+	git(assert, source, "checkout", "-q", "-b", "feature")
+	head := commit(assert, source, "feature")
+	git(assert, source, "update-ref", "refs/pull/1/head", head)
+	git(assert, source, "checkout", "-q", "-")
+	git(assert, source, "branch", "-q", "-D", "feature")
+
+	status, err := Mirror(source, dest, false)
+	assert.NoError(err)
+	assert.Equal(Updated, status)
+	assert.Equal(head, git(assert, dest, "rev-parse", "refs/pull/1/head"))
+}
+
+func TestMirrorOverwrite(t *testing.T) {
+	for _, overwrite := range []bool{false, true} {
+		t.Run(fmt.Sprintf("overwrite:%v", overwrite), func(t *testing.T) {
+			assert := require.New(t)
+
+			tmpdir, err := ioutil.TempDir("", "")
+			assert.NoError(err)
+			defer os.RemoveAll(tmpdir)
+			source := newSource(assert, tmpdir)
+			dest := filepath.Join(tmpdir, "dest.git")
+			git(assert, source, "branch", "feature")
+			original := commit(assert, source, "second")
+			_, err = Mirror(source, dest, false)
+			assert.NoError(err)
+
+			// Rewrite history and delete a branch.
+			git(assert, source, "reset", "-q", "--hard", "HEAD~1")
+			rewritten := commit(assert, source, "rewritten")
+			git(assert, source, "branch", "-D", "feature")
+
+			// Run.
+			var status string
+			logs, _, _, err := testUtils.WithLogging(func() {
+				status, err = Mirror(source, dest, overwrite)
+				assert.NoError(err)
+			})
+			assert.NoError(err)
+
+			// Verify.
+			branches := git(assert, dest, "branch", "--list")
+			if overwrite {
+				assert.Equal(Reset, status)
+				assert.Equal(rewritten, git(assert, dest, "rev-parse", "HEAD"))
+				assert.NotContains(branches, "feature")
+			} else {
+				assert.Equal(Updated, status)
+				assert.Equal(original, git(assert, dest, "rev-parse", "HEAD"))
+				assert.Contains(branches, "feature")
+				assert.Equal("Some local refs diverged from the remote and were left as-is.", logs.LastEntry().Message)
+				assert.Equal(1, logs.LastEntry().Data["rejected"])
+			}
+		})
+	}
+}
+
+func TestMirrorRejectedAndFailed(t *testing.T) {
+	assert := require.New(t)
+
+	tmpdir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpdir)
+	source := newSource(assert, tmpdir)
+	dest := filepath.Join(tmpdir, "dest.git")
+	git(assert, source, "branch", "feature")
+	commit(assert, source, "second")
+	_, err = Mirror(source, dest, false)
+	assert.NoError(err)
+
+	// Rewrite master and update feature, which can't be written locally.
+	git(assert, source, "reset", "-q", "--hard", "HEAD~1")
+	commit(assert, source, "rewritten")
+	git(assert, source, "checkout", "-q", "feature")
+	commit(assert, source, "feature")
+	assert.NoError(ioutil.WriteFile(filepath.Join(dest, "refs", "heads", "feature.lock"), nil, 0644))
+
+	// Run.
+	logs, _, _, err := testUtils.WithLogging(func() {
+		_, err := Mirror(source, dest, false)
+		assert.Error(err)
+		assert.Contains(err.Error(), "git fetch failed: ")
+		assert.NotContains(err.Error(), "non-fast-forward")
+	})
+	assert.NoError(err)
+	assert.Equal(1, logs.LastEntry().Data["rejected"])
+}
+
+func TestCountRejected(t *testing.T) {
+	assert := require.New(t)
+	output := `From /tmp/source
+ ! [rejected]        master     -> master  (non-fast-forward)
+ ! [rejected]        v1         -> v1  (would clobber existing tag)
+   985e13f..21d7640  feature    -> feature
+`
+	count, problem := countRejected(output)
+	assert.Equal(2, count)
+	assert.Equal("", problem)
+
+	count, problem = countRejected(output + "fatal: unable to access 'https://github.com/me/repo.git/': timeout\n")
+	assert.Equal(2, count)
+	assert.Equal("fatal: unable to access 'https://github.com/me/repo.git/': timeout", problem)
+}
+
+func TestMirrorSkipped(t *testing.T) {
+	assert := require.New(t)
+
+	tmpdir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpdir)
+	source := newSource(assert, tmpdir)
+	dest := filepath.Join(tmpdir, "dest.git")
+	assert.NoError(os.Mkdir(dest, os.ModePerm))
+
+	for _, overwrite := range []bool{false, true} {
+		status, err := Mirror(source, dest, overwrite)
+		assert.NoError(err)
+		assert.Equal(Skipped, status)
+	}
+}
+
+func TestMirrorError(t *testing.T) {
+	assert := require.New(t)
+
+	tmpdir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpdir)
+	dest := filepath.Join(tmpdir, "dest.git")
+
+	status, err := Mirror(filepath.Join(tmpdir, "dne"), dest, false)
+	assert.Error(err)
+	assert.Contains(err.Error(), "git clone failed: ")
+	assert.Equal("", status)
+
+	// Nothing left behind.
+	_, err = os.Stat(dest)
+	assert.True(os.IsNotExist(err))
+}
+
+func TestNotFound(t *testing.T) {
+	outputs := map[string]bool{
+		"remote: Repository not found.\nfatal: repository 'https://github.com/a/b.wiki.git/' not found": true,
+		"ERROR: Repository not found.\nfatal: Could not read from remote repository.":                   true,
+		"fatal: unable to access 'https://github.com/a/b.git/': Could not resolve host: github.com":     false,
+		"fatal: Authentication failed for 'https://github.com/a/b.git/'":                                false,
+	}
+	for output, expected := range outputs {
+		t.Run(output, func(t *testing.T) {
+			assert := require.New(t)
+			assert.Equal(expected, reNotFound.MatchString(output))
+		})
+	}
+}
+
+func TestRemoteURL(t *testing.T) {
+	assert := require.New(t)
+
+	tmpdir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpdir)
+	source := newSource(assert, tmpdir)
+	dest := filepath.Join(tmpdir, "dest.git")
+	_, err = Mirror(source, dest, false)
+	assert.NoError(err)
+
+	url, err := RemoteURL(dest)
+	assert.NoError(err)
+	assert.Equal(source, url)
+
+	_, err = RemoteURL(source) // No remotes.
+	assert.Error(err)
+}
+
+func TestHead(t *testing.T) {
+	assert := require.New(t)
+
+	tmpdir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpdir)
+	source := newSource(assert, tmpdir)
+	dest := filepath.Join(tmpdir, "dest.git")
+	_, err = Mirror(source, dest, false)
+	assert.NoError(err)
+
+	head, err := Head(dest)
+	assert.NoError(err)
+	assert.Equal(git(assert, source, "rev-parse", "HEAD"), head)
+
+	_, err = Head(filepath.Join(tmpdir, "dne.git"))
+	assert.Error(err)
+}
+
+func TestAuth(t *testing.T) {
+	assert := require.New(t)
+	defer SetAuth(Auth{})
+
+	// Nothing by default.
+	args, env := authArgs()
+	assert.Empty(args)
+	assert.Empty(env)
+
+	// SSH options.
+	SetAuth(Auth{SSHKey: "/keys/it's", KnownHosts: "/known hosts"})
+	args, env = authArgs()
+	assert.Empty(args)
+	expected := `GIT_SSH_COMMAND=ssh -i '/keys/it'\''s' -o IdentitiesOnly=yes -o UserKnownHostsFile='/known hosts'`
+	assert.Equal([]string{expected}, env)
+
+	// The credential helper answers with the token from the environment.
+	SetAuth(Auth{Token: "secret"})
+	args, env = authArgs()
+	assert.NotContains(strings.Join(args, " "), "secret")
+	cmd := exec.Command("git", append(args, "credential", "fill")...)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdin = strings.NewReader("protocol=https\nhost=github.com\n\n")
+	output, err := cmd.CombinedOutput()
+	assert.NoError(err, string(output))
+	assert.Contains(string(output), "username=x-access-token\npassword=secret\n")
+
+	// Not saved into the clone.
+	tmpdir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpdir)
+	dest := filepath.Join(tmpdir, "dest.git")
+	logs, _, _, err := testUtils.WithLogging(func() {
+		_, err := Mirror(newSource(assert, tmpdir), dest, false)
+		assert.NoError(err)
+	})
+	assert.NoError(err)
+	assert.NotContains(git(assert, dest, "config", "--list"), "credential")
+	for _, entry := range logs.Entries {
+		assert.NotContains(fmt.Sprint(entry.Data), "secret")
+	}
+}
+
+func TestVersion(t *testing.T) {
+	assert := require.New(t)
+
+	version, err := Version()
+	assert.NoError(err)
+	assert.Contains(version, "git version ")
+}
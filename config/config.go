@@ -1,19 +1,41 @@
 package config
 
 import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
 	"github.com/docopt/docopt-go"
 )
 
 // Version is the semantic version of the program.
 const Version = "0.0.1"
 
+// DefaultJobs is the number of repos/Gists backed up concurrently if --jobs isn't given.
+const DefaultJobs = 1
+
+// Affiliations are the values accepted by --affiliation.
+var Affiliations = []string{"owner", "collaborator", "organization_member"}
+
+// CloneProtocols are the values accepted by --clone-protocol. The first one is the default.
+var CloneProtocols = []string{"auto", "https", "ssh"}
+
+// DefaultRetries is the number of times failed API requests are retried if --retries isn't given.
+const DefaultRetries = 3
+
 const usage = `Backup all of your GitHub repos (with issues/wikis) and Gists.
 
 Clone all of your public and private repos into individual local directories in
 the DESTINATION directory. Does a mirror clone so all branches and tags are
 fully cloned.
 
-Also downloads all of your GitHub Issues, Wiki pages, and releases, along with
+Also downloads all of your GitHub Issues, pull requests (with review comments,
+reviews and timelines), Wiki pages, releases, and repo settings (description,
+topics, collaborators, branch protection, webhooks and deploy keys), along with
 all of your GitHub Gists. Each Gist is its own Git repo so each one will be
 cloned to their own individual directory locally.
 
@@ -21,31 +43,106 @@ If the --user option is specified then that users' repos/gists will be backed
 up instead of the authenticated users'. When specified the personal API token
 is optional.
 
+The personal API token is read from (first one found): --token, --token-file,
+the GITHUB_TOKEN environment variable, git credential helpers (git credential
+fill for the API host), and finally a password prompt.
+
+Repos of GitHub organizations given with --org are backed up as well, each
+organization in its own DESTINATION/orgs/ORG directory.
+
+Without --user, repos owned by other users (shared with you) are backed up into
+DESTINATION/users/OWNER/NAME and other organizations' repos into
+DESTINATION/orgs/ORG/NAME. --affiliation picks which of them are listed: a
+comma separated list of owner, collaborator (repos shared with you) and
+organization_member.
+
+Repos and gists can be picked with --include and --exclude patterns, matched
+against repo names, OWNER/NAME and gist IDs. Patterns are globs (e.g. "go-*")
+or regular expressions between slashes (e.g. "/^go-.*$/"). With --include only
+matching items are backed up. Items matching --exclude are always skipped.
+
+Repos can also be filtered by what GitHub knows about them: --no-archived skips
+archived repos, --topic and --language only keep repos with one of the given
+topics or primary languages (case-insensitive) and --max-size skips repos larger
+than the given size in KB (as reported by GitHub).
+
+With --starred the repos you starred are mirror-cloned as well (without wikis,
+issues or releases), each into DESTINATION/starred/OWNER/NAME. The filters above
+apply to them too.
+
+Repos and gists are cloned over HTTPS or SSH as chosen by --clone-protocol. The
+default (auto) uses SSH for private repos and HTTPS for everything else. HTTPS
+clones authenticate with the personal API token, which is handed to git by a
+credential helper and never saved into the cloned repos. SSH clones use your ssh
+configuration unless --ssh-key or --known-hosts are given.
+
+For GitHub Enterprise set --api-url to your server's API endpoint (usually
+https://HOST/api/v3/). Clone URLs are pointed at the same host.
+
+A JSON report of every run (status of each repo and gist, errors, API usage) is
+written to DESTINATION/.githubBackup/report.json or to --report (only the latter
+if DESTINATION or the log file can't be used). The exit status is 0 on success,
+1 if the backup couldn't run, 2 for invalid options or log file and 3 if some
+repos or gists failed to back up.
+
+With --dry-run nothing is backed up. Instead every repo, wiki, gist, issue set
+and release that would be created or updated is listed with its target path
+and estimated size, as a table or as JSON with --json.
+
+Options (and DESTINATION) may also be set in a TOML config file, read from
+~/.githubBackup.toml or from --config. Keys are long option names without the
+leading dashes, e.g. token = "abc" or no-forks = true. Command line options take
+precedence over the config file.
+
 Usage:
-    githubBackup [options] DESTINATION
+    githubBackup [options] [--org=ORG...] [--include=PAT...] [--exclude=PAT...]
+                 [--topic=TOPIC...] [--language=LANG...] [DESTINATION]
     githubBackup -h | --help
     githubBackup -V | --version
 
 Options:
-    -C --no-colors      Disable colored log levels and field keys.
-    -D --no-releases    Skip backing up your repo releases/downloads.
-    -E --no-private     Skip backing up your private repos and secret Gists.
-    -F --no-forks       Skip backing up forked repos (doesn't apply to Gists).
-    -G --no-gist        Skip backing up your GitHub Gists.
-    -h --help           Show this screen.
-    -I --no-issues      Skip backing up your repo issues.
-    -l FILE --log=FILE  Log output to file.
-    -M --no-comments    Skip backing up your Gist comments.
-    -P --no-public      Skip backing up your public repos and public Gists.
-    -q --quiet          Don't print anything to stdout/stderr (implies -T).
-    -R --no-repos       Skip backing up your GitHub repos.
-    -t TKN --token=TKN  Use this GitHub personal access token.
-    -T --no-prompt      Skip prompting for keyboard input.
-    -u USER --user=USER GitHub user to lookup.
-    -v --verbose        Debug logging.
-    -V --version        Show version and exit.
-    -w --overwrite      Do git reset on existing directories.
-    -W --no-wikis       Skip backing up your repo wikis.
+    -a URL --api-url=URL       GitHub API base URL (for GitHub Enterprise).
+    -A --no-archived           Skip backing up archived repos.
+    -B --no-metadata           Skip backing up your repo settings and collaborators.
+    -c FILE --config=FILE      Read options from this config file.
+    -C --no-colors             Disable colored log levels and field keys.
+    -D --no-releases           Skip backing up your repo releases/downloads.
+    -E --no-private            Skip backing up your private repos and secret Gists.
+    -f FILE --token-file=FILE  Read the GitHub personal access token from this file.
+    -F --no-forks              Skip backing up forked repos (doesn't apply to Gists).
+    -G --no-gist               Skip backing up your GitHub Gists.
+    -h --help                  Show this screen.
+    -H KH --known-hosts=KH     Check SSH host keys against known_hosts file KH.
+    -i PAT --include=PAT       Only backup repos/Gists matching this pattern.
+    -I --no-issues             Skip backing up your repo issues.
+    -j N --jobs=N              Back up N repos/Gists concurrently (default 1).
+    -J --json                  Print the --dry-run listing as JSON.
+    -k TOPIC --topic=TOPIC     Only backup repos with this topic.
+    -K FILE --ssh-key=FILE     Clone over SSH with this private key.
+    -l FILE --log=FILE         Log output to file.
+    -L LANG --language=LANG    Only backup repos with this primary language.
+    -m AFF --affiliation=AFF   Only list repos with this affiliation to you.
+    -M --no-comments           Skip backing up your Gist comments.
+    -n --dry-run               Only show what would be backed up and where.
+    -N --no-pulls              Skip backing up your repo pull requests.
+    -o ORG --org=ORG           Also backup repos of this GitHub organization.
+    -O FILE --report=FILE      Write the JSON run report to this file.
+    -p P --clone-protocol=P    Clone over protocol P: auto, https or ssh.
+    -P --no-public             Skip backing up your public repos and public Gists.
+    -q --quiet                 Don't print anything to stdout/stderr (implies -T).
+    -r N --retries=N           Retry failed GitHub API requests N times (default 3).
+    -R --no-repos              Skip backing up your GitHub repos.
+    -s --starred               Also backup repos you starred (clones only).
+    -S KB --max-size=KB        Skip repos larger than KB kilobytes (0 for no limit).
+    -t TKN --token=TKN         Use this GitHub personal access token.
+    -T --no-prompt             Skip prompting for keyboard input.
+    -u USER --user=USER        GitHub user to lookup.
+    -U URL --upload-url=URL    GitHub upload URL (derived from --api-url if unset).
+    -v --verbose               Debug logging.
+    -V --version               Show version and exit.
+    -w --overwrite             Do git reset on existing directories.
+    -W --no-wikis              Skip backing up your repo wikis.
+    -x PAT --exclude=PAT       Skip repos/Gists matching this pattern.
 `
 
 func parseString(value interface{}) string {
@@ -55,6 +152,87 @@ func parseString(value interface{}) string {
 	return value.(string)
 }
 
+func parseStrings(value interface{}) []string {
+	if value == nil {
+		return nil
+	}
+	return value.([]string)
+}
+
+func parseInt(value interface{}, option string, defaultValue, minimum int) (int, error) {
+	if value == nil {
+		return defaultValue, nil
+	}
+	i, err := strconv.Atoi(value.(string))
+	if err != nil || i < minimum {
+		return 0, fmt.Errorf("invalid %s value, must be %d or more: %s", option, minimum, value.(string))
+	}
+	return i, nil
+}
+
+// checkAffiliation returns an error if the --affiliation value isn't a comma separated list of Affiliations.
+func checkAffiliation(value string) error {
+	for _, affiliation := range strings.Split(value, ",") {
+		valid := false
+		for _, known := range Affiliations {
+			valid = valid || affiliation == known
+		}
+		if !valid {
+			msg := "invalid --affiliation value, must be a comma separated list of %s: %s"
+			return fmt.Errorf(msg, strings.Join(Affiliations, ", "), value)
+		}
+	}
+	return nil
+}
+
+// checkCloneProtocol returns an error if the --clone-protocol value isn't one of CloneProtocols.
+func checkCloneProtocol(value string) error {
+	for _, known := range CloneProtocols {
+		if value == known {
+			return nil
+		}
+	}
+	msg := "invalid --clone-protocol value, must be one of %s: %s"
+	return fmt.Errorf(msg, strings.Join(CloneProtocols, ", "), value)
+}
+
+// ParseBaseURL validates a GitHub API URL. The GitHub library requires a trailing slash on base URLs.
+//
+// :param option: Command line option the URL came from, for the error message.
+func ParseBaseURL(option, value string) (*url.URL, error) {
+	parsed, err := url.Parse(value)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, fmt.Errorf("invalid %s, must be an http(s) URL: %s", option, value)
+	}
+	if !strings.HasSuffix(parsed.Path, "/") {
+		parsed.Path += "/"
+	}
+	return parsed, nil
+}
+
+// IsRegexp returns true if the --include/--exclude pattern is a regular expression (between slashes) instead of a glob.
+func IsRegexp(pattern string) bool {
+	return len(pattern) >= 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/")
+}
+
+// CheckPatterns returns an error for the first invalid pattern.
+//
+// :param option: Command line option the patterns came from, for the error message.
+func CheckPatterns(option string, patterns []string) error {
+	for _, pattern := range patterns {
+		var err error
+		if IsRegexp(pattern) {
+			_, err = regexp.Compile(pattern[1 : len(pattern)-1])
+		} else {
+			_, err = path.Match(pattern, "")
+		}
+		if err != nil {
+			return fmt.Errorf("invalid %s pattern %s: %s", option, pattern, err.Error())
+		}
+	}
+	return nil
+}
+
 func parseBool(value interface{}) bool {
 	if value == nil {
 		return false
@@ -64,23 +242,46 @@ func parseBool(value interface{}) bool {
 
 // Config holds parsed data from command line arguments.
 type Config struct { // Sorted by docopt short option names above.
-	NoColors   bool
-	NoReleases bool
-	NoPrivate  bool
-	NoForks    bool
-	NoGist     bool
-	NoIssues   bool
-	LogFile    string
-	NoComments bool
-	NoPublic   bool
-	Quiet      bool
-	NoRepos    bool
-	Token      string
-	NoPrompt   bool
-	User       string
-	Verbose    bool
-	Overwrite  bool
-	NoWikis    bool
+	APIURL        string
+	NoArchived    bool
+	NoMetadata    bool
+	ConfigFile    string
+	NoColors      bool
+	NoReleases    bool
+	NoPrivate     bool
+	TokenFile     string
+	NoForks       bool
+	NoGist        bool
+	KnownHosts    string
+	Includes      []string
+	NoIssues      bool
+	Jobs          int
+	JSON          bool
+	Topics        []string
+	SSHKey        string
+	LogFile       string
+	Languages     []string
+	Affiliation   string
+	NoComments    bool
+	DryRun        bool
+	NoPulls       bool
+	Orgs          []string
+	Report        string
+	CloneProtocol string
+	NoPublic      bool
+	Quiet         bool
+	Retries       int
+	NoRepos       bool
+	Starred       bool
+	MaxSize       int
+	Token         string
+	NoPrompt      bool
+	User          string
+	UploadURL     string
+	Verbose       bool
+	Overwrite     bool
+	NoWikis       bool
+	Excludes      []string
 
 	Destination string
 }
@@ -95,25 +296,73 @@ func NewConfig(argv []string) (Config, error) {
 		return Config{}, err
 	}
 
+	// Read config file.
+	file, err := mergeFile(parseString(parsed["--config"]), parsed)
+	if err != nil {
+		return Config{}, err
+	}
+	configFile := ""
+	if file != nil {
+		configFile = file.path
+	}
+	if parseString(parsed["DESTINATION"]) == "" {
+		return Config{}, errors.New("no DESTINATION given on the command line or in a config file")
+	}
+	jobs, err := parseInt(parsed["--jobs"], "--jobs", DefaultJobs, 1)
+	if err != nil {
+		return Config{}, file.wrap("--jobs", err)
+	}
+	retries, err := parseInt(parsed["--retries"], "--retries", DefaultRetries, 0)
+	if err != nil {
+		return Config{}, file.wrap("--retries", err)
+	}
+	maxSize, err := parseInt(parsed["--max-size"], "--max-size", 0, 0)
+	if err != nil {
+		return Config{}, file.wrap("--max-size", err)
+	}
+
 	// Populate struct.
 	config := Config{ // Sorted by Config struct field order above.
-		NoColors:   parseBool(parsed["--no-colors"]),
-		NoReleases: parseBool(parsed["--no-releases"]),
-		NoPrivate:  parseBool(parsed["--no-private"]),
-		NoForks:    parseBool(parsed["--no-forks"]),
-		NoGist:     parseBool(parsed["--no-gist"]),
-		NoIssues:   parseBool(parsed["--no-issues"]),
-		LogFile:    parseString(parsed["--log"]),
-		NoComments: parseBool(parsed["--no-comments"]),
-		NoPublic:   parseBool(parsed["--no-public"]),
-		Quiet:      parseBool(parsed["--quiet"]),
-		NoRepos:    parseBool(parsed["--no-repos"]),
-		Token:      parseString(parsed["--token"]),
-		NoPrompt:   parseBool(parsed["--no-prompt"]),
-		User:       parseString(parsed["--user"]),
-		Verbose:    parseBool(parsed["--verbose"]),
-		Overwrite:  parseBool(parsed["--overwrite"]),
-		NoWikis:    parseBool(parsed["--no-wikis"]),
+		APIURL:        parseString(parsed["--api-url"]),
+		NoArchived:    parseBool(parsed["--no-archived"]),
+		NoMetadata:    parseBool(parsed["--no-metadata"]),
+		ConfigFile:    configFile,
+		NoColors:      parseBool(parsed["--no-colors"]),
+		NoReleases:    parseBool(parsed["--no-releases"]),
+		NoPrivate:     parseBool(parsed["--no-private"]),
+		TokenFile:     parseString(parsed["--token-file"]),
+		NoForks:       parseBool(parsed["--no-forks"]),
+		NoGist:        parseBool(parsed["--no-gist"]),
+		KnownHosts:    parseString(parsed["--known-hosts"]),
+		Includes:      parseStrings(parsed["--include"]),
+		NoIssues:      parseBool(parsed["--no-issues"]),
+		Jobs:          jobs,
+		JSON:          parseBool(parsed["--json"]),
+		Topics:        parseStrings(parsed["--topic"]),
+		SSHKey:        parseString(parsed["--ssh-key"]),
+		LogFile:       parseString(parsed["--log"]),
+		Languages:     parseStrings(parsed["--language"]),
+		Affiliation:   parseString(parsed["--affiliation"]),
+		NoComments:    parseBool(parsed["--no-comments"]),
+		DryRun:        parseBool(parsed["--dry-run"]),
+		NoPulls:       parseBool(parsed["--no-pulls"]),
+		Orgs:          parseStrings(parsed["--org"]),
+		Report:        parseString(parsed["--report"]),
+		CloneProtocol: parseString(parsed["--clone-protocol"]),
+		NoPublic:      parseBool(parsed["--no-public"]),
+		Quiet:         parseBool(parsed["--quiet"]),
+		Retries:       retries,
+		NoRepos:       parseBool(parsed["--no-repos"]),
+		Starred:       parseBool(parsed["--starred"]),
+		MaxSize:       maxSize,
+		Token:         parseString(parsed["--token"]),
+		NoPrompt:      parseBool(parsed["--no-prompt"]),
+		User:          parseString(parsed["--user"]),
+		UploadURL:     parseString(parsed["--upload-url"]),
+		Verbose:       parseBool(parsed["--verbose"]),
+		Overwrite:     parseBool(parsed["--overwrite"]),
+		NoWikis:       parseBool(parsed["--no-wikis"]),
+		Excludes:      parseStrings(parsed["--exclude"]),
 
 		Destination: parseString(parsed["DESTINATION"]),
 	}
@@ -122,6 +371,38 @@ func NewConfig(argv []string) (Config, error) {
 	if config.Quiet {
 		config.NoPrompt = true
 	}
+	if config.JSON && !config.DryRun {
+		return Config{}, errors.New("--json only applies to --dry-run")
+	}
+	if config.CloneProtocol == "" {
+		config.CloneProtocol = CloneProtocols[0]
+	} else if err := checkCloneProtocol(config.CloneProtocol); err != nil {
+		return Config{}, file.wrap("--clone-protocol", err)
+	}
+	if config.Affiliation != "" {
+		if config.User != "" {
+			return Config{}, errors.New("--affiliation only applies without --user")
+		}
+		if err := checkAffiliation(config.Affiliation); err != nil {
+			return Config{}, file.wrap("--affiliation", err)
+		}
+	}
+	if config.APIURL != "" {
+		if _, err := ParseBaseURL("--api-url", config.APIURL); err != nil {
+			return Config{}, file.wrap("--api-url", err)
+		}
+	}
+	if config.UploadURL != "" {
+		if _, err := ParseBaseURL("--upload-url", config.UploadURL); err != nil {
+			return Config{}, file.wrap("--upload-url", err)
+		}
+	}
+	if err := CheckPatterns("--include", config.Includes); err != nil {
+		return Config{}, file.wrap("--include", err)
+	}
+	if err := CheckPatterns("--exclude", config.Excludes); err != nil {
+		return Config{}, file.wrap("--exclude", err)
+	}
 
 	return config, nil
 }
@@ -22,4 +22,173 @@ func TestNewConfig(t *testing.T) {
 	assert.Equal("dest_dir", cfg.Destination)
 	assert.False(cfg.Quiet)
 	assert.Equal("", cfg.LogFile)
+	assert.Equal("", cfg.Report)
+	assert.False(cfg.Starred)
+
+	cfg, err = NewConfig([]string{"-O", "report.json", "--starred", "dest_dir"})
+	assert.NoError(err)
+	assert.Equal("report.json", cfg.Report)
+	assert.True(cfg.Starred)
+}
+
+func TestNewConfigOrgs(t *testing.T) {
+	assert := require.New(t)
+
+	cfg, err := NewConfig([]string{"dest_dir"})
+	assert.NoError(err)
+	assert.Empty(cfg.Orgs)
+
+	cfg, err = NewConfig([]string{"-o", "one", "--org", "two", "--org=three", "dest_dir"})
+	assert.NoError(err)
+	assert.Equal([]string{"one", "two", "three"}, cfg.Orgs)
+	assert.Equal("dest_dir", cfg.Destination)
+}
+
+func TestNewConfigURLs(t *testing.T) {
+	assert := require.New(t)
+
+	cfg, err := NewConfig([]string{"--api-url", "https://ghe/api/v3/", "-U", "https://ghe/api/uploads/", "dest_dir"})
+	assert.NoError(err)
+	assert.Equal("https://ghe/api/v3/", cfg.APIURL)
+	assert.Equal("https://ghe/api/uploads/", cfg.UploadURL)
+
+	_, err = NewConfig([]string{"--api-url", "ghe.example.com", "dest_dir"})
+	assert.EqualError(err, "invalid --api-url, must be an http(s) URL: ghe.example.com")
+}
+
+func TestNewConfigRetries(t *testing.T) {
+	assert := require.New(t)
+
+	cfg, err := NewConfig([]string{"dest_dir"})
+	assert.NoError(err)
+	assert.Equal(DefaultRetries, cfg.Retries)
+
+	cfg, err = NewConfig([]string{"--retries", "0", "dest_dir"})
+	assert.NoError(err)
+	assert.Equal(0, cfg.Retries)
+
+	_, err = NewConfig([]string{"-r", "many", "dest_dir"})
+	assert.EqualError(err, "invalid --retries value, must be 0 or more: many")
+}
+
+func TestNewConfigJobs(t *testing.T) {
+	assert := require.New(t)
+
+	cfg, err := NewConfig([]string{"dest_dir"})
+	assert.NoError(err)
+	assert.Equal(DefaultJobs, cfg.Jobs)
+
+	cfg, err = NewConfig([]string{"--jobs", "8", "dest_dir"})
+	assert.NoError(err)
+	assert.Equal(8, cfg.Jobs)
+
+	_, err = NewConfig([]string{"-j", "0", "dest_dir"})
+	assert.EqualError(err, "invalid --jobs value, must be 1 or more: 0")
+}
+
+func TestNewConfigDryRun(t *testing.T) {
+	assert := require.New(t)
+
+	cfg, err := NewConfig([]string{"dest_dir"})
+	assert.NoError(err)
+	assert.False(cfg.DryRun)
+	assert.False(cfg.JSON)
+
+	cfg, err = NewConfig([]string{"-nJ", "dest_dir"})
+	assert.NoError(err)
+	assert.True(cfg.DryRun)
+	assert.True(cfg.JSON)
+
+	_, err = NewConfig([]string{"--json", "dest_dir"})
+	assert.EqualError(err, "--json only applies to --dry-run")
+}
+
+func TestNewConfigPatterns(t *testing.T) {
+	assert := require.New(t)
+
+	cfg, err := NewConfig([]string{"dest_dir"})
+	assert.NoError(err)
+	assert.Empty(cfg.Includes)
+	assert.Empty(cfg.Excludes)
+
+	cfg, err = NewConfig([]string{"-i", "go-*", "--include=/^py/", "-x", "*-old", "dest_dir"})
+	assert.NoError(err)
+	assert.Equal([]string{"go-*", "/^py/"}, cfg.Includes)
+	assert.Equal([]string{"*-old"}, cfg.Excludes)
+	assert.Equal("dest_dir", cfg.Destination)
+
+	_, err = NewConfig([]string{"-x", "/(/", "dest_dir"})
+	assert.EqualError(err, "invalid --exclude pattern /(/: error parsing regexp: missing closing ): `(`")
+}
+
+func TestCheckPatterns(t *testing.T) {
+	assert := require.New(t)
+
+	assert.NoError(CheckPatterns("--include", nil))
+	assert.NoError(CheckPatterns("--include", []string{"go-*", "/^go-.*$/", "/", "a/b"}))
+	assert.EqualError(CheckPatterns("--include", []string{"ok", "[a-"}),
+		"invalid --include pattern [a-: syntax error in pattern")
+	assert.EqualError(CheckPatterns("--exclude", []string{"/(/"}),
+		"invalid --exclude pattern /(/: error parsing regexp: missing closing ): `(`")
+}
+
+func TestNewConfigRepoFilters(t *testing.T) {
+	assert := require.New(t)
+
+	cfg, err := NewConfig([]string{"dest_dir"})
+	assert.NoError(err)
+	assert.False(cfg.NoArchived)
+	assert.Empty(cfg.Topics)
+	assert.Empty(cfg.Languages)
+	assert.Equal(0, cfg.MaxSize)
+
+	argv := []string{"-A", "-k", "cli", "--topic=backup", "-L", "Go", "--language=python", "-S", "1024", "dest_dir"}
+	cfg, err = NewConfig(argv)
+	assert.NoError(err)
+	assert.True(cfg.NoArchived)
+	assert.Equal([]string{"cli", "backup"}, cfg.Topics)
+	assert.Equal([]string{"Go", "python"}, cfg.Languages)
+	assert.Equal(1024, cfg.MaxSize)
+	assert.Equal("dest_dir", cfg.Destination)
+
+	_, err = NewConfig([]string{"--max-size=-1", "dest_dir"})
+	assert.EqualError(err, "invalid --max-size value, must be 0 or more: -1")
+}
+
+func TestNewConfigAffiliation(t *testing.T) {
+	assert := require.New(t)
+
+	cfg, err := NewConfig([]string{"dest_dir"})
+	assert.NoError(err)
+	assert.Equal("", cfg.Affiliation)
+
+	cfg, err = NewConfig([]string{"-m", "owner,collaborator", "dest_dir"})
+	assert.NoError(err)
+	assert.Equal("owner,collaborator", cfg.Affiliation)
+
+	_, err = NewConfig([]string{"--affiliation=owner,friend", "dest_dir"})
+	msg := "invalid --affiliation value, must be a comma separated list of owner, collaborator, organization_member: "
+	assert.EqualError(err, msg+"owner,friend")
+
+	_, err = NewConfig([]string{"--affiliation=owner", "--user=me", "dest_dir"})
+	assert.EqualError(err, "--affiliation only applies without --user")
+}
+
+func TestNewConfigCloneProtocol(t *testing.T) {
+	assert := require.New(t)
+
+	cfg, err := NewConfig([]string{"dest_dir"})
+	assert.NoError(err)
+	assert.Equal("auto", cfg.CloneProtocol)
+	assert.Equal("", cfg.SSHKey)
+	assert.Equal("", cfg.KnownHosts)
+
+	cfg, err = NewConfig([]string{"-p", "ssh", "-K", "id_rsa", "--known-hosts=known_hosts", "dest_dir"})
+	assert.NoError(err)
+	assert.Equal("ssh", cfg.CloneProtocol)
+	assert.Equal("id_rsa", cfg.SSHKey)
+	assert.Equal("known_hosts", cfg.KnownHosts)
+
+	_, err = NewConfig([]string{"--clone-protocol=git", "dest_dir"})
+	assert.EqualError(err, "invalid --clone-protocol value, must be one of auto, https, ssh: git")
 }
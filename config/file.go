@@ -0,0 +1,246 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DefaultConfigFile is read if it exists and --config isn't given.
+const DefaultConfigFile = ".githubBackup.toml"
+
+var reKey = regexp.MustCompile(`^([A-Za-z0-9_-]+)\s*=\s*(.*)$`)
+
+// fileError is an error in the config file pointing to the offending line.
+type fileError struct {
+	path string
+	line int
+	msg  string
+}
+
+func (e *fileError) Error() string {
+	return fmt.Sprintf("%s line %d: %s", e.path, e.line, e.msg)
+}
+
+// defaultConfigPath returns the path to the config file in the user's home directory.
+func defaultConfigPath() string {
+	home := os.Getenv("HOME")
+	if home == "" {
+		home = os.Getenv("USERPROFILE")
+	}
+	if home == "" {
+		return ""
+	}
+	return filepath.Join(home, DefaultConfigFile)
+}
+
+// parseValue parses one TOML value. Supports strings, booleans, integers and single line arrays of strings. Integers
+// are returned as strings since docopt option arguments are strings.
+func parseValue(text string) (interface{}, string, error) {
+	switch {
+	case strings.HasPrefix(text, `"`):
+		end := 1
+		for ; end < len(text) && text[end] != '"'; end++ {
+			if text[end] == '\\' {
+				end++
+			}
+		}
+		if end >= len(text) {
+			return nil, "", fmt.Errorf("unterminated string")
+		}
+		value, err := strconv.Unquote(text[:end+1])
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid string %s", text[:end+1])
+		}
+		return value, text[end+1:], nil
+	case strings.HasPrefix(text, "'"):
+		end := strings.Index(text[1:], "'")
+		if end < 0 {
+			return nil, "", fmt.Errorf("unterminated string")
+		}
+		return text[1 : end+1], text[end+2:], nil
+	case strings.HasPrefix(text, "["):
+		values := []string{}
+		rest := strings.TrimSpace(text[1:])
+		for !strings.HasPrefix(rest, "]") {
+			if !strings.HasPrefix(rest, `"`) && !strings.HasPrefix(rest, "'") {
+				return nil, "", fmt.Errorf("arrays may only contain strings")
+			}
+			value, remaining, err := parseValue(rest)
+			if err != nil {
+				return nil, "", err
+			}
+			values = append(values, value.(string))
+			rest = strings.TrimSpace(remaining)
+			if strings.HasPrefix(rest, ",") {
+				rest = strings.TrimSpace(rest[1:])
+			} else if !strings.HasPrefix(rest, "]") {
+				return nil, "", fmt.Errorf("expected , or ] in array")
+			}
+		}
+		return values, rest[1:], nil
+	}
+
+	// Bare words.
+	word := text
+	if i := strings.IndexAny(text, " \t#,]"); i >= 0 {
+		word = text[:i]
+	}
+	switch word {
+	case "true":
+		return true, text[len(word):], nil
+	case "false":
+		return false, text[len(word):], nil
+	}
+	if _, err := strconv.Atoi(word); err == nil {
+		return word, text[len(word):], nil
+	}
+	return nil, "", fmt.Errorf("invalid value %q", word)
+}
+
+// readFile reads a subset of TOML: comments, blank lines and top level key = value pairs. Returned keys are docopt
+// keys (e.g. "no-forks" becomes "--no-forks" and "destination" becomes "DESTINATION"), along with the line number of
+// each key.
+//
+// :param path: Config file to read.
+//
+// :param parsed: Output of docopt.Parse(), used to validate keys and value types.
+func readFile(path string, parsed map[string]interface{}) (map[string]interface{}, map[string]int, error) {
+	handle, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer handle.Close()
+
+	values := map[string]interface{}{}
+	lines := map[string]int{}
+	scanner := bufio.NewScanner(handle)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if strings.HasPrefix(text, "[") {
+			return nil, nil, &fileError{path, line, "tables are not supported"}
+		}
+		match := reKey.FindStringSubmatch(text)
+		if match == nil {
+			return nil, nil, &fileError{path, line, "expected key = value"}
+		}
+		key := match[1]
+
+		// Parse value.
+		value, rest, err := parseValue(match[2])
+		if err != nil {
+			return nil, nil, &fileError{path, line, fmt.Sprintf("key %q: %s", key, err.Error())}
+		}
+		if rest = strings.TrimSpace(rest); rest != "" && !strings.HasPrefix(rest, "#") {
+			return nil, nil, &fileError{path, line, fmt.Sprintf("key %q: unexpected text after value", key)}
+		}
+
+		// Validate key and type.
+		docoptKey := "--" + key
+		if key == "destination" {
+			docoptKey = "DESTINATION"
+		}
+		current, ok := parsed[docoptKey]
+		if !ok || key == "help" || key == "version" || key == "config" {
+			return nil, nil, &fileError{path, line, fmt.Sprintf("unknown key %q", key)}
+		}
+		if prev, ok := lines[docoptKey]; ok {
+			return nil, nil, &fileError{path, line, fmt.Sprintf("key %q already set on line %d", key, prev)}
+		}
+		var typeOK bool
+		var typeName string
+		switch current.(type) {
+		case bool:
+			_, typeOK = value.(bool)
+			typeName = "a boolean"
+		case []string:
+			if str, isStr := value.(string); isStr {
+				value = []string{str}
+			}
+			_, typeOK = value.([]string)
+			typeName = "a string or an array of strings"
+		default:
+			_, typeOK = value.(string)
+			typeName = "a string"
+		}
+		if !typeOK {
+			return nil, nil, &fileError{path, line, fmt.Sprintf("key %q must be %s", key, typeName)}
+		}
+
+		values[docoptKey] = value
+		lines[docoptKey] = line
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return values, lines, nil
+}
+
+// configFile is a config file merged by mergeFile().
+type configFile struct {
+	path  string
+	lines map[string]int // Line numbers of values taken from the file (not overridden on the command line).
+}
+
+// wrap turns an error about an invalid option value into a fileError pointing to its line if the value came from the
+// config file. Other errors are returned as they are.
+//
+// :param key: Docopt key of the option, e.g. "--jobs".
+func (f *configFile) wrap(key string, err error) error {
+	if f == nil || err == nil {
+		return err
+	}
+	line, ok := f.lines[key]
+	if !ok {
+		return err
+	}
+	return &fileError{f.path, line, fmt.Sprintf("key %q: %s", strings.TrimPrefix(key, "--"), err.Error())}
+}
+
+// mergeFile reads the config file and fills in options not given on the command line.
+//
+// :param path: Config file from --config. If empty the default config file is read if it exists.
+//
+// :param parsed: Output of docopt.Parse(), modified in place.
+//
+// :return: The config file read (nil if there was none) with the line numbers of the values it filled in.
+func mergeFile(path string, parsed map[string]interface{}) (*configFile, error) {
+	if path == "" {
+		path = defaultConfigPath()
+		if _, err := os.Stat(path); path == "" || err != nil {
+			return nil, nil
+		}
+	}
+
+	values, lines, err := readFile(path, parsed)
+	if err != nil {
+		return nil, err
+	}
+	file := &configFile{path: path, lines: map[string]int{}}
+	for key, value := range values {
+		switch current := parsed[key].(type) {
+		case bool:
+			parsed[key] = current || value.(bool)
+		case []string:
+			if len(current) == 0 {
+				parsed[key] = value
+				file.lines[key] = lines[key]
+			}
+		default:
+			if current == nil {
+				parsed[key] = value
+				file.lines[key] = lines[key]
+			}
+		}
+	}
+
+	return file, nil
+}
@@ -0,0 +1,143 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfigFile(assert *require.Assertions, dir, contents string) string {
+	path := filepath.Join(dir, "config.toml")
+	assert.NoError(ioutil.WriteFile(path, []byte(contents), 0600))
+	return path
+}
+
+func TestNewConfigFile(t *testing.T) {
+	assert := require.New(t)
+	tmpdir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpdir)
+
+	path := writeConfigFile(assert, tmpdir, `# Nightly backup.
+destination = "/backups/github"
+token = 'abc'  # Comment.
+user = "file\"user"
+no-forks = true
+no-wikis = false
+org = ["one", "two"]
+exclude = ["*-old", '/^tmp-\d+$/']
+`)
+
+	// File only.
+	cfg, err := NewConfig([]string{"--config", path})
+	assert.NoError(err)
+	assert.Equal(path, cfg.ConfigFile)
+	assert.Equal("/backups/github", cfg.Destination)
+	assert.Equal("abc", cfg.Token)
+	assert.Equal(`file"user`, cfg.User)
+	assert.True(cfg.NoForks)
+	assert.False(cfg.NoWikis)
+	assert.Equal([]string{"one", "two"}, cfg.Orgs)
+	assert.Equal([]string{"*-old", `/^tmp-\d+$/`}, cfg.Excludes)
+
+	// Command line takes precedence.
+	cfg, err = NewConfig([]string{"-c", path, "-t", "xyz", "--no-wikis", "--org", "three", "dest"})
+	assert.NoError(err)
+	assert.Equal("dest", cfg.Destination)
+	assert.Equal("xyz", cfg.Token)
+	assert.Equal(`file"user`, cfg.User)
+	assert.True(cfg.NoForks)
+	assert.True(cfg.NoWikis)
+	assert.Equal([]string{"three"}, cfg.Orgs)
+}
+
+func TestNewConfigDefaultFile(t *testing.T) {
+	assert := require.New(t)
+	tmpdir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpdir)
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	assert.NoError(os.Setenv("HOME", tmpdir))
+
+	// No file.
+	_, err = NewConfig([]string{})
+	assert.EqualError(err, "no DESTINATION given on the command line or in a config file")
+	cfg, err := NewConfig([]string{"dest"})
+	assert.NoError(err)
+	assert.Equal("", cfg.ConfigFile)
+
+	// File in home directory.
+	path := filepath.Join(tmpdir, DefaultConfigFile)
+	assert.NoError(ioutil.WriteFile(path, []byte("destination = \"dest\"\norg = \"one\"\n"), 0600))
+	cfg, err = NewConfig([]string{})
+	assert.NoError(err)
+	assert.Equal(path, cfg.ConfigFile)
+	assert.Equal("dest", cfg.Destination)
+	assert.Equal([]string{"one"}, cfg.Orgs)
+
+	// Explicit file must exist.
+	_, err = NewConfig([]string{"-c", filepath.Join(tmpdir, "dne.toml")})
+	assert.Error(err)
+}
+
+func TestNewConfigFileErrors(t *testing.T) {
+	testCases := []struct {
+		contents string
+		expected string
+	}{
+		{"token = \"abc\"\n\ntokn = \"abc\"", `line 3: unknown key "tokn"`},
+		{"help = true", `line 1: unknown key "help"`},
+		{"no-forks = \"yes\"", `line 1: key "no-forks" must be a boolean`},
+		{"token = true", `line 1: key "token" must be a string`},
+		{"org = [1]", `line 1: key "org": arrays may only contain strings`},
+		{"org = [\"a\" \"b\"]", `line 1: key "org": expected , or ] in array`},
+		{"token = \"abc", `line 1: key "token": unterminated string`},
+		{"token = abc", `line 1: key "token": invalid value "abc"`},
+		{"token = \"abc\" def", `line 1: key "token": unexpected text after value`},
+		{"# Comment.\n[section]", "line 2: tables are not supported"},
+		{"token", "line 1: expected key = value"},
+		{"user = \"a\"\nuser = \"b\"", `line 2: key "user" already set on line 1`},
+		{"# Comment.\njobs = 0", `line 2: key "jobs": invalid --jobs value, must be 1 or more: 0`},
+		{"retries = -1", `line 1: key "retries": invalid --retries value, must be 0 or more: -1`},
+		{"max-size = -1", `line 1: key "max-size": invalid --max-size value, must be 0 or more: -1`},
+		{"clone-protocol = \"git\"", `line 1: key "clone-protocol": invalid --clone-protocol value, must be one ` +
+			`of auto, https, ssh: git`},
+		{"affiliation = \"me\"", `line 1: key "affiliation": invalid --affiliation value, must be a comma ` +
+			`separated list of owner, collaborator, organization_member: me`},
+		{"api-url = \"ghe.example.com\"", `line 1: key "api-url": invalid --api-url, must be an http(s) URL: ` +
+			`ghe.example.com`},
+		{"upload-url = \"ftp://ghe/\"", `line 1: key "upload-url": invalid --upload-url, must be an http(s) ` +
+			`URL: ftp://ghe/`},
+		{"include = [\"ok\", \"[a-\"]", `line 1: key "include": invalid --include pattern [a-: syntax error in ` +
+			`pattern`},
+		{"# Comment.\nexclude = [\"/(/\"]", `line 2: key "exclude": invalid --exclude pattern /(/: error parsing ` +
+			"regexp: missing closing ): `(`"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.expected, func(t *testing.T) {
+			assert := require.New(t)
+			tmpdir, err := ioutil.TempDir("", "")
+			assert.NoError(err)
+			defer os.RemoveAll(tmpdir)
+			path := writeConfigFile(assert, tmpdir, tc.contents)
+
+			_, err = NewConfig([]string{"-c", path, "dest"})
+			assert.EqualError(err, path+" "+tc.expected)
+		})
+	}
+}
+
+func TestNewConfigFileCLIError(t *testing.T) {
+	assert := require.New(t)
+	tmpdir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpdir)
+	path := writeConfigFile(assert, tmpdir, "jobs = 2")
+
+	// Invalid values given on the command line aren't blamed on the config file.
+	_, err = NewConfig([]string{"-c", path, "-j", "0", "dest"})
+	assert.EqualError(err, "invalid --jobs value, must be 1 or more: 0")
+}
@@ -7,19 +7,30 @@ import (
 	"path/filepath"
 	"reflect"
 	"strings"
+	"sync"
 
 	"github.com/Robpol86/logrus-custom-formatter"
 	"github.com/Sirupsen/logrus"
 	"github.com/rifflock/lfshook"
 )
 
-const glide = "%[level]s  %[message]s\n"
+const glide = "%[level]s  %[prefix]s%[message]s\n"
 
 func levelHandler(entry *logrus.Entry, formatter *lcf.CustomFormatter) (interface{}, error) {
 	level := "[" + strings.ToUpper(entry.Level.String()[:4]) + "]"
 	return lcf.Color(entry, formatter, level), nil
 }
 
+// prefixHandler tags messages with the repo or gist they're about, so output of concurrent backups can be told apart.
+func prefixHandler(entry *logrus.Entry, formatter *lcf.CustomFormatter) (interface{}, error) {
+	for _, key := range []string{"repo", "gist"} {
+		if value, ok := entry.Data[key]; ok {
+			return fmt.Sprintf("[%v] ", value), nil
+		}
+	}
+	return "", nil
+}
+
 // GetLogger is a convenience function that returns a logrus logger with the "name" field already filled out.
 func GetLogger() *logrus.Entry {
 	return logrus.WithField("name", lcf.CallerName(2))
@@ -29,7 +40,7 @@ func getFormatter(verbose, disableColors, forceColors bool) (formatter *lcf.Cust
 	if verbose {
 		formatter = lcf.NewFormatter(lcf.Detailed, nil)
 	} else {
-		formatter = lcf.NewFormatter(glide, lcf.CustomHandlers{"level": levelHandler})
+		formatter = lcf.NewFormatter(glide, lcf.CustomHandlers{"level": levelHandler, "prefix": prefixHandler})
 	}
 	if disableColors {
 		formatter.DisableColors = true
@@ -55,6 +66,7 @@ type logFileHook struct {
 	logger  *logrus.Logger
 	lfsHook logrus.Hook
 	error
+	mu sync.Mutex // Repos are backed up concurrently.
 }
 
 func (h *logFileHook) Levels() []logrus.Level {
@@ -62,6 +74,8 @@ func (h *logFileHook) Levels() []logrus.Level {
 }
 
 func (h *logFileHook) Fire(entry *logrus.Entry) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
 	old := entry.Logger
 	defer func() { entry.Logger = old }()
 	entry.Logger = h.logger
@@ -75,12 +89,14 @@ func (h *logFileHook) Fire(entry *logrus.Entry) error {
 //
 // :param quiet: Disable any logging to the console.
 //
+// :param stderrOnly: Log everything to stderr, keeping stdout free for program output.
+//
 // :param disableColors: Disable color log levels and field keys.
 //
 // :param forceColors: Force showing colors (for testing).
 //
 // :param logFile: Log to this file path in addition to the console.
-func SetupLogging(verbose, quiet, disableColors, forceColors bool, logFile string) (err error) {
+func SetupLogging(verbose, quiet, stderrOnly, disableColors, forceColors bool, logFile string) (err error) {
 	if quiet {
 		logrus.SetOutput(ioutil.Discard)
 		if logFile == "" {
@@ -103,6 +119,9 @@ func SetupLogging(verbose, quiet, disableColors, forceColors bool, logFile strin
 	if !quiet {
 		// Handle stdout/stderr.
 		logrus.SetOutput(os.Stdout) // Default is stdout for info/debug which are emitted most often.
+		if stderrOnly {
+			logrus.SetOutput(os.Stderr)
+		}
 		// logrus.Entry.log() is a non-pointer receiver function so it's goroutine safe to re-define
 		// *entry.Logger. The only race condition is between hooks since there is no locking. However .log()
 		// calls all hooks in series, not parallel. Therefore it should be ok to "duplicate" Logger and only
@@ -128,7 +147,7 @@ func SetupLogging(verbose, quiet, disableColors, forceColors bool, logFile strin
 	})
 	loggerCopy := reflect.ValueOf(*logrus.StandardLogger()).Interface().(logrus.Logger)
 	loggerCopy.Formatter = getFormatter(verbose, true, false) // New formatter.
-	hook := logFileHook{&loggerCopy, lfs, nil}
+	hook := logFileHook{logger: &loggerCopy, lfsHook: lfs}
 	logrus.AddHook(&hook)
 
 	// Emit debug log and check for errors.
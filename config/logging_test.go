@@ -61,7 +61,7 @@ func runSetupLogging(assert *require.Assertions, verbose, quiet, hasLogFile bool
 	// Run.
 	stdout, stderr, err := testUtils.WithCapSys(func() {
 		testUtils.ResetLogger()
-		err := SetupLogging(verbose, quiet, false, true, logFile)
+		err := SetupLogging(verbose, quiet, false, false, true, logFile)
 		assert.NoError(err)
 		testUtils.LogMsgs()
 	})
@@ -239,7 +239,7 @@ func TestSetupLoggingLogFileError(t *testing.T) {
 			// Run.
 			stdout, stderr, err := testUtils.WithCapSys(func() {
 				testUtils.ResetLogger()
-				err := SetupLogging(false, true, false, true, logFile)
+				err := SetupLogging(false, true, false, false, true, logFile)
 				assert.Error(err)
 				assert.True(strings.HasSuffix(err.Error(), expectedSuffix), err.Error())
 			})
@@ -252,3 +252,17 @@ func TestSetupLoggingLogFileError(t *testing.T) {
 		})
 	}
 }
+
+func TestPrefixHandler(t *testing.T) {
+	assert := require.New(t)
+
+	for fields, expected := range map[string]string{"": "", "repo": "[one] ", "gist": "[one] ", "other": ""} {
+		entry := logrus.WithField("name", "test")
+		if fields != "" {
+			entry = entry.WithField(fields, "one")
+		}
+		prefix, err := prefixHandler(entry, nil)
+		assert.NoError(err)
+		assert.Equal(expected, prefix, fields)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Robpol86/githubBackup/api"
+	"github.com/Robpol86/githubBackup/config"
+)
+
+// Actions listed by a dry run.
+const (
+	actionCreate    = "create"
+	actionUpdate    = "update"
+	actionUnchanged = "unchanged"
+)
+
+// planItem is one thing a backup would create or update.
+type planItem struct {
+	Type   string // repo, wiki, metadata, issues, pulls, releases, starred, gist or comments.
+	Name   string
+	Action string // create, update or unchanged (not pushed to since the last backup).
+	Path   string
+	Size   int64 // Estimated bytes. 0 if unknown.
+}
+
+// createOrUpdate returns the action for a file or directory that's always written.
+func createOrUpdate(path string) string {
+	if _, err := os.Stat(path); err == nil {
+		return actionUpdate
+	}
+	return actionCreate
+}
+
+// cloneAction returns what the backup would do with a clone. Only reads the local clone and the state file.
+func cloneAction(cfg *config.Config, state *backupState, dir string, pushedAt, updatedAt time.Time) string {
+	action := createOrUpdate(dir)
+	if action == actionUpdate && !cfg.Overwrite && state.unchanged(dir, pushedAt, updatedAt) {
+		return actionUnchanged
+	}
+	return action
+}
+
+// plan lists everything a backup would create or update, in the order it would be backed up.
+func plan(cfg *config.Config, ghRepos, ghStarred *api.GitHubRepos, ghGists *api.GitHubGists) []planItem {
+	state := loadState(cfg.Destination)
+	var items []planItem
+
+	for _, repo := range append(append(api.GitHubRepos{}, *ghRepos...), *ghStarred...) {
+		dir := repoDir(cfg.Destination, repo)
+		name := repo.Name
+		if repo.Owner != "" {
+			name = repo.Owner + "/" + repo.Name
+		}
+		cloneDir := filepath.Join(dir, repo.Name+".git")
+		action := cloneAction(cfg, state, cloneDir, repo.PushedAt, repo.UpdatedAt)
+		kind := "repo"
+		if repo.Starred {
+			kind = "starred"
+		}
+		items = append(items, planItem{kind, name, action, cloneDir, int64(repo.Size) * 1024}) // Size is in KB.
+		if repo.WikiURL != "" {
+			wikiDir := filepath.Join(dir, repo.Name+".wiki.git")
+			items = append(items, planItem{"wiki", name, createOrUpdate(wikiDir), wikiDir, 0})
+		}
+		if !cfg.NoMetadata && !repo.Starred {
+			path := filepath.Join(dir, api.MetadataFile)
+			items = append(items, planItem{"metadata", name, createOrUpdate(path), path, 0})
+		}
+		if repo.HasIssues {
+			path := filepath.Join(dir, api.IssuesFile)
+			items = append(items, planItem{"issues", name, createOrUpdate(path), path, 0})
+		}
+		if !cfg.NoPulls && !repo.Starred {
+			path := filepath.Join(dir, api.PullsDir)
+			items = append(items, planItem{"pulls", name, createOrUpdate(path), path, 0})
+		}
+		if !cfg.NoReleases && !repo.Starred {
+			path := filepath.Join(dir, api.ReleasesFile)
+			items = append(items, planItem{"releases", name, createOrUpdate(path), path, 0})
+		}
+	}
+
+	for _, gist := range *ghGists {
+		dir := gistDir(cfg.Destination, gist)
+		cloneDir := filepath.Join(dir, gist.ID+".git")
+		action := cloneAction(cfg, state, cloneDir, gist.PushedAt, gist.PushedAt)
+		items = append(items, planItem{"gist", gist.ID, action, cloneDir, int64(gist.Size)})
+		if gist.HasComments {
+			path := filepath.Join(dir, api.GistCommentsFile)
+			items = append(items, planItem{"comments", gist.ID, createOrUpdate(path), path, 0})
+		}
+	}
+
+	return items
+}
+
+// humanSize formats a number of bytes for the dry run table.
+func humanSize(size int64) string {
+	if size <= 0 {
+		return "-"
+	}
+	value, units := float64(size), []string{"B", "KB", "MB", "GB"}
+	i := 0
+	for ; value >= 1024 && i < len(units)-1; i++ {
+		value /= 1024
+	}
+	if i == 0 {
+		return fmt.Sprintf("%d B", size)
+	}
+	return fmt.Sprintf("%.1f %s", value, units[i])
+}
+
+// DryRun prints what Backup() would create or update instead of doing it, as a table or as JSON.
+//
+// :param out: Write the listing here (stdout outside of tests).
+func DryRun(cfg *config.Config, ghRepos, ghStarred *api.GitHubRepos, ghGists *api.GitHubGists, out io.Writer) error {
+	items := plan(cfg, ghRepos, ghStarred, ghGists)
+	if cfg.JSON {
+		if items == nil {
+			items = []planItem{} // Print [] instead of null.
+		}
+		data, err := json.MarshalIndent(items, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(out, string(data))
+		return err
+	}
+
+	var total int64
+	var changes int
+	writer := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "TYPE\tACTION\tSIZE\tNAME\tPATH")
+	for _, item := range items {
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\n", item.Type, item.Action, humanSize(item.Size), item.Name, item.Path)
+		if item.Action == actionCreate || item.Action == actionUpdate {
+			changes++
+			total += item.Size
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	estimate := humanSize(total)
+	if total == 0 {
+		estimate = "0 B"
+	}
+	msg := "%d of %d item%s would be created or updated (%s estimated).\n"
+	_, err := fmt.Fprintf(out, msg, changes, len(items), plural(len(items), "", "s"), estimate)
+	return err
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Robpol86/githubBackup/api"
+	"github.com/Robpol86/githubBackup/config"
+	"github.com/Robpol86/githubBackup/testUtils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHumanSize(t *testing.T) {
+	assert := require.New(t)
+
+	assert.Equal("-", humanSize(0))
+	assert.Equal("512 B", humanSize(512))
+	assert.Equal("1.5 KB", humanSize(1536))
+	assert.Equal("2.0 MB", humanSize(2*1024*1024))
+	assert.Equal("2048.0 GB", humanSize(2*1024*1024*1024*1024))
+}
+
+func TestDryRun(t *testing.T) {
+	assert := require.New(t)
+
+	tmpdir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpdir)
+	dest := filepath.Join(tmpdir, "dest")
+	assert.NoError(os.Mkdir(dest, os.ModePerm))
+
+	pushedAt := time.Date(2016, 10, 20, 0, 0, 0, 0, time.UTC)
+	cfg := config.Config{Destination: dest, NoReleases: true, NoPulls: true, NoMetadata: true}
+	ghRepos := api.GitHubRepos{
+		{Name: "one", Owner: "me", Size: 2, CloneURL: newSource(assert, filepath.Join(tmpdir, "one")),
+			PushedAt: pushedAt},
+		{Name: "two", Owner: "me", Size: 3, CloneURL: "https://github.com/me/two.git", HasIssues: true,
+			WikiURL: "https://github.com/me/two.wiki.git"},
+	}
+	ghGists := api.GitHubGists{
+		{ID: "abc123", Size: 10, CloneURL: "https://gist.github.com/abc123.git", HasComments: true},
+	}
+
+	// Nothing backed up yet.
+	var out bytes.Buffer
+	assert.NoError(DryRun(&cfg, &ghRepos, &api.GitHubRepos{}, &ghGists, &out))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Len(lines, 8)
+	assert.Regexp(`^TYPE +ACTION +SIZE +NAME +PATH$`, lines[0])
+	cloneDir := regexp.QuoteMeta(filepath.Join(dest, "one", "one.git"))
+	assert.Regexp(`^repo +create +2\.0 KB +me/one +`+cloneDir+`$`, lines[1])
+	assert.Regexp(`^wiki +create +- +me/two +`, lines[3])
+	assert.Regexp(`^issues +create +- +me/two +`, lines[4])
+	assert.Regexp(`^comments +create +- +abc123 +`, lines[6])
+	assert.Equal("6 of 6 items would be created or updated (5.0 KB estimated).", lines[len(lines)-1])
+	entries, err := ioutil.ReadDir(dest)
+	assert.NoError(err)
+	assert.Empty(entries)
+
+	// After a backup.
+	ghRepos = ghRepos[:1]
+	ghGists = nil
+	_, _, _, err = testUtils.WithLogging(func() {
+		assert.NoError(Backup(&cfg, &api.API{}, &ghRepos, &api.GitHubRepos{}, &ghGists, &Report{}))
+	})
+	assert.NoError(err)
+	cfg.JSON = true
+	out.Reset()
+	assert.NoError(DryRun(&cfg, &ghRepos, &api.GitHubRepos{}, &ghGists, &out))
+	var items []planItem
+	assert.NoError(json.Unmarshal(out.Bytes(), &items))
+	assert.Equal([]planItem{{"repo", "me/one", "unchanged", filepath.Join(dest, "one", "one.git"), 2048}}, items)
+
+	// Overwrite always fetches.
+	cfg.Overwrite = true
+	assert.Equal(actionUpdate, plan(&cfg, &ghRepos, &api.GitHubRepos{}, &ghGists)[0].Action)
+
+	// Pull requests.
+	cfg.NoPulls = false
+	items = plan(&cfg, &ghRepos, &api.GitHubRepos{}, &ghGists)
+	assert.Equal(planItem{"pulls", "me/one", "create", filepath.Join(dest, "one", api.PullsDir), 0}, items[1])
+
+	// Metadata.
+	cfg.NoMetadata = false
+	items = plan(&cfg, &ghRepos, &api.GitHubRepos{}, &ghGists)
+	assert.Equal(planItem{"metadata", "me/one", "create", filepath.Join(dest, "one", api.MetadataFile), 0}, items[1])
+	assert.Equal("pulls", items[2].Type)
+
+	// Starred repos are only cloned.
+	cfg.NoReleases = false
+	ghStarred := api.GitHubRepos{{Name: "lib", Owner: "upstream", Starred: true, Size: 1}}
+	items = plan(&cfg, &api.GitHubRepos{}, &ghStarred, &ghGists)
+	starredDir := filepath.Join(dest, "starred", "upstream", "lib", "lib.git")
+	assert.Equal([]planItem{{"starred", "upstream/lib", "create", starredDir, 1024}}, items)
+}
@@ -8,17 +8,20 @@ import (
 	"math"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/Robpol86/githubBackup/api"
+	"github.com/Robpol86/githubBackup/clone"
 	"github.com/Robpol86/githubBackup/config"
 	"github.com/Sirupsen/logrus"
 )
 
 const touchFile = ".githubBackup.txt"
 
-// VerifyDest validates and creates the destination directory.
-func VerifyDest(dir string, noPrompt bool) error {
+// VerifyDest validates and creates the destination directory. With dryRun it only checks an existing directory without
+// prompting, creating or writing anything.
+func VerifyDest(dir string, noPrompt, dryRun bool) error {
 	log := config.GetLogger().WithField("dir", dir)
 	stat, err := os.Stat(dir)
 
@@ -34,11 +37,14 @@ func VerifyDest(dir string, noPrompt bool) error {
 			return err
 		}
 		defer d.Close()
+		if dryRun {
+			return nil
+		}
 		if _, err = d.Readdirnames(1); err != io.EOF {
 			log.Warn("Destination path exists and is not empty. The followig will happen:")
-			log.Warn("Issues: repos with already backed-up issues will be skipped/not updated.")
+			log.Warn("Issues: already backed-up issues will be updated with new and changed issues and comments.")
 			log.Warn("Releases: Already-downloaded assets won't be overwritten.")
-			log.Warn("Already cloned repositories will be force updated locally.")
+			log.Warn("Already cloned repositories will be fetched (force updated locally with --overwrite).")
 			if !noPrompt {
 				message := "Press Enter to continue..."
 				log.WithField("prompt", message).Debug("Prompting for enter key.")
@@ -47,6 +53,10 @@ func VerifyDest(dir string, noPrompt bool) error {
 			}
 		}
 	} else if os.IsNotExist(err) { // Create if not exist.
+		if dryRun {
+			log.Debug("Destination directory doesn't exist yet.")
+			return nil
+		}
 		if err = os.Mkdir(dir, os.ModePerm); err != nil {
 			log.Errorf("Failed creating directory: %s", err.Error())
 			return err
@@ -82,7 +92,24 @@ func toFields(counts map[string]int) logrus.Fields {
 	return fields
 }
 
-func logSummary(ghRepos *api.GitHubRepos, ghGists *api.GitHubGists) {
+// skipSummary formats repos skipped by --no-archived, --topic, --language and --max-size for logSummary(), e.g.
+// "3 archived, 2 too large". Returns the total number of skipped repos.
+func skipSummary(skipped map[string]int) (int, string) {
+	var total int
+	var parts []string
+	for _, reason := range api.SkipReasons {
+		if skipped[reason] > 0 {
+			total += skipped[reason]
+			parts = append(parts, fmt.Sprintf("%d %s", skipped[reason], reason))
+		}
+	}
+	return total, strings.Join(parts, ", ")
+}
+
+// logSummary logs what Collect() found.
+//
+// :param skipped: Number of repos left out by filters, keyed by api.Skip* reasons.
+func logSummary(ghRepos, ghStarred *api.GitHubRepos, ghGists *api.GitHubGists, skipped map[string]int) {
 	log := config.GetLogger()
 
 	// Repos.
@@ -103,9 +130,18 @@ func logSummary(ghRepos *api.GitHubRepos, ghGists *api.GitHubGists) {
 		} else {
 			log.Infof("--> %d of them have GitHub Issues.", counts["issues"])
 		}
+		if counts["archived"] > 0 {
+			log.Infof("--> %d of them are archived.", counts["archived"])
+		}
 	} else {
 		log.WithFields(toFields(counts)).Warn("Didn't find any GitHub repositories to backup.")
 	}
+	if s := len(*ghStarred); s > 0 {
+		log.Infof("Found %d starred repo%s.", s, plural(s, "", "s"))
+	}
+	if total, summary := skipSummary(skipped); total > 0 {
+		log.WithFields(toFields(skipped)).Infof("Skipped %d repo%s (%s).", total, plural(total, "", "s"), summary)
+	}
 
 	// Gists.
 	if counts := ghGists.Counts(); counts["all"] > 0 {
@@ -132,6 +168,12 @@ func rateLimitWarning(cfg *config.Config, ghAPI *api.API, ghRepos *api.GitHubRep
 		if !cfg.NoIssues {
 			forecast += ghRepos.Counts()["issues"]
 		}
+		if !cfg.NoPulls {
+			forecast += len(*ghRepos)
+		}
+		if !cfg.NoMetadata {
+			forecast += len(*ghRepos)
+		}
 	}
 	if len(*ghGists) > 0 {
 		forecast += ghGists.Counts()["comments"]
@@ -142,21 +184,14 @@ func rateLimitWarning(cfg *config.Config, ghAPI *api.API, ghRepos *api.GitHubRep
 
 	log := config.GetLogger()
 	eta := int(math.Ceil(-time.Since(ghAPI.Reset.Time).Minutes()))
-	msg := "Only %d API quer%s of %d remain. This may interrupt the program."
+	msg := "Only %d API quer%s of %d remain. The program will pause when they run out."
 	log.WithField("forecast", forecast).Warnf(msg, ghAPI.Remaining, plural(ghAPI.Remaining, "y", "ies"), ghAPI.Limit)
 	msg = "GitHub will reset the counter in %d minute%s."
 	log.WithField("reset", ghAPI.Reset).Warnf(msg, eta, plural(eta, "", "s"))
-
-	if !cfg.NoPrompt {
-		message := "Press Enter to continue..."
-		log.WithField("prompt", message).Debug("Prompting for enter key.")
-		fmt.Print(message)
-		bufio.NewReader(os.Stdin).ReadBytes('\n')
-	}
 }
 
 // Collect gathers initial information via GitHub APIs to find out what should be backed up.
-func Collect(cfg *config.Config, ghAPI *api.API, ghRepos *api.GitHubRepos, ghGists *api.GitHubGists) error {
+func Collect(cfg *config.Config, ghAPI *api.API, ghRepos, ghStarred *api.GitHubRepos, ghGists *api.GitHubGists) error {
 	log := config.GetLogger()
 	log.WithFields(ghAPI.Fields()).Info("Querying GitHub API...")
 
@@ -166,64 +201,137 @@ func Collect(cfg *config.Config, ghAPI *api.API, ghRepos *api.GitHubRepos, ghGis
 			return err
 		}
 	}
+	if len(cfg.Orgs) > 0 {
+		if err := ghAPI.GetOrgRepos(ghRepos); err != nil {
+			log.Errorf("Querying GitHub API for organization repositories failed: %s", err.Error())
+			return err
+		}
+	}
+	if cfg.Starred {
+		if err := ghAPI.GetStarred(ghRepos, ghStarred); err != nil {
+			log.Errorf("Querying GitHub API for starred repositories failed: %s", err.Error())
+			return err
+		}
+	}
 	if !cfg.NoGist {
 		if err := ghAPI.GetGists(ghGists); err != nil {
 			log.Errorf("Querying GitHub API for gists failed: %s", err.Error())
 			return err
 		}
 	}
-	if len(*ghRepos) == 0 && len(*ghGists) == 0 {
+	if len(*ghRepos) == 0 && len(*ghStarred) == 0 && len(*ghGists) == 0 {
 		log.Warn("No repos or gists to backup. Nothing to do.")
 		return errors.New("no repos or gists to backup")
 	}
 
 	// Log messages.
-	logSummary(ghRepos, ghGists)
+	logSummary(ghRepos, ghStarred, ghGists, ghAPI.SkippedRepos)
 	rateLimitWarning(cfg, ghAPI, ghRepos, ghGists)
 	return nil
 }
 
+// runBackup queries the GitHub API and backs up everything found. Returns the API (nil if it couldn't be set up) and
+// the exit status.
+func runBackup(cfg *config.Config, testURL string, report *Report) (*api.API, int) {
+	log := config.GetLogger()
+
+	// Getting token from user.
+	ghAPI, err := api.NewAPI(*cfg, "")
+	if err != nil {
+		log.Errorf("Not querying GitHub API: %s", err.Error())
+		report.Error = err.Error()
+		return nil, exitFailed
+	}
+
+	// Credentials for git.
+	clone.SetAuth(clone.Auth{Token: ghAPI.Token, SSHKey: cfg.SSHKey, KnownHosts: cfg.KnownHosts})
+
+	// Query APIs for repos and gists.
+	ghAPI.TestURL = testURL
+	ghRepos := api.GitHubRepos{}
+	ghStarred := api.GitHubRepos{}
+	ghGists := api.GitHubGists{}
+	if err := Collect(cfg, &ghAPI, &ghRepos, &ghStarred, &ghGists); err != nil {
+		report.Error = err.Error()
+		return &ghAPI, exitFailed
+	}
+
+	// Only list what would be backed up.
+	if cfg.DryRun {
+		if err := DryRun(cfg, &ghRepos, &ghStarred, &ghGists, os.Stdout); err != nil {
+			log.Errorf("Failed to print dry run: %s", err.Error())
+			return &ghAPI, exitFailed
+		}
+		return &ghAPI, exitOK
+	}
+
+	// Clone repos/gists and export their data.
+	if err := Backup(cfg, &ghAPI, &ghRepos, &ghStarred, &ghGists, report); err != nil {
+		return &ghAPI, exitPartial
+	}
+
+	return &ghAPI, exitOK
+}
+
+// writeReport finishes the report and writes it to --report. Returns the exit status, exitFailed if writing failed
+// after an otherwise successful run. Dry runs don't write a report.
+//
+// :param destVerified: False if VerifyDest() didn't succeed (yet). The report is then only written if --report points
+// outside of DESTINATION, the default location would create DESTINATION.
+func writeReport(cfg *config.Config, report *Report, ret int, ghAPI *api.API, destVerified bool) int {
+	if cfg.DryRun || (!destVerified && cfg.Report == "") {
+		return ret
+	}
+	log := config.GetLogger()
+	report.finish(ret, ghAPI)
+	path := reportPath(cfg.Report, cfg.Destination)
+	if err := report.write(path); err != nil {
+		log.WithField("file", path).Errorf("Failed to write report: %s", err.Error())
+		if ret == exitOK {
+			ret = exitFailed
+		}
+	} else {
+		log.WithField("file", path).Debug("Wrote report.")
+	}
+	return ret
+}
+
 // Main holds the main logic of the program. It exists for testing (vs putting logic in main()).
 //
 // :param argv: CLI arguments to pass to docopt.Parse().
 //
 // :param testURL: For testing only. Query this base URL instead of the GitHub API URL.
 func Main(argv []string, testURL string) int {
+	start := time.Now()
+
 	// Initialize configuration.
 	cfg, err := config.NewConfig(argv)
 	if err != nil {
 		// Shouldn't really happen since docopt does os.Exit().
 		fmt.Fprintln(os.Stderr, "ERROR: Failed to initialize configuration: "+err.Error())
-		return 2
+		return exitConfig
 	}
-	err = config.SetupLogging(cfg.Verbose, cfg.Quiet, cfg.NoColors, false, cfg.LogFile)
+	err = config.SetupLogging(cfg.Verbose, cfg.Quiet, cfg.JSON, cfg.NoColors, false, cfg.LogFile)
 	log := config.GetLogger() // SetupLogging only errors on log file setup and removes log hook. Logging is safe.
+	report := newReport(start)
 	if err != nil {
 		log.Errorf("Failed to setup logging: %s", err.Error())
-		return 2
+		report.Error = "failed to setup logging: " + err.Error()
+		return writeReport(&cfg, report, exitConfig, nil, false)
 	}
-
-	// Verify destination.
-	if err := VerifyDest(cfg.Destination, cfg.NoPrompt); err != nil {
-		return 1
-	}
-
-	// Getting token from user.
-	ghAPI, err := api.NewAPI(cfg, "")
-	if err != nil {
-		log.Errorf("Not querying GitHub API: %s", err.Error())
-		return 1
+	if cfg.ConfigFile != "" {
+		log.WithField("file", cfg.ConfigFile).Debug("Read options from config file.")
 	}
 
-	// Query APIs for repos and gists.
-	ghAPI.TestURL = testURL
-	ghRepos := api.GitHubRepos{}
-	ghGists := api.GitHubGists{}
-	if err := Collect(&cfg, &ghAPI, &ghRepos, &ghGists); err != nil {
-		return 1
+	// Verify destination.
+	if err := VerifyDest(cfg.Destination, cfg.NoPrompt, cfg.DryRun); err != nil {
+		report.Error = err.Error()
+		return writeReport(&cfg, report, exitFailed, nil, false)
 	}
 
-	return 0
+	// Back up and write the report.
+	ghAPI, ret := runBackup(&cfg, testURL, report)
+	return writeReport(&cfg, report, ret, ghAPI, true)
 }
 
 // main is the real main function that is called automatically when running the program.
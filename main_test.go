@@ -2,6 +2,8 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
@@ -10,6 +12,7 @@ import (
 	"regexp"
 	"runtime"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -41,7 +44,7 @@ func TestVerifyDestValid(t *testing.T) {
 			// Run.
 			defer testUtils.ResetLogger()
 			logs, stdout, stderr, err := testUtils.WithLogging(func() {
-				assert.NoError(VerifyDest(dest, false))
+				assert.NoError(VerifyDest(dest, false, false))
 			})
 
 			// Verify logs.
@@ -109,7 +112,7 @@ func TestVerifyDestInvalid(t *testing.T) {
 			// Run.
 			defer testUtils.ResetLogger()
 			logs, _, stderr, err := testUtils.WithLogging(func() {
-				assert.Error(VerifyDest(dest, false))
+				assert.Error(VerifyDest(dest, false, false))
 			})
 
 			// Verify logs.
@@ -145,7 +148,7 @@ func TestCollectFail(t *testing.T) {
 		t.Run(failOn, func(t *testing.T) {
 			assert := require.New(t)
 			logs, stdout, stderr, err := testUtils.WithLogging(func() {
-				assert.Error(Collect(&cfg, &ghAPI, &ghRepos, &ghGists))
+				assert.Error(Collect(&cfg, &ghAPI, &ghRepos, &api.GitHubRepos{}, &ghGists))
 			})
 			assert.NoError(err)
 			assert.Empty(stderr)
@@ -190,7 +193,7 @@ func TestCollect(t *testing.T) {
 	ghRepos := api.GitHubRepos{}
 	ghGists := api.GitHubGists{}
 	logs, stdout, stderr, err := testUtils.WithLogging(func() {
-		assert.NoError(Collect(&cfg, &ghAPI, &ghRepos, &ghGists))
+		assert.NoError(Collect(&cfg, &ghAPI, &ghRepos, &api.GitHubRepos{}, &ghGists))
 	})
 	assert.NoError(err)
 	assert.Empty(stderr)
@@ -205,6 +208,44 @@ func TestCollect(t *testing.T) {
 	assert.Equal(logs.Entries[8], logs.LastEntry())
 }
 
+func TestCollectFiltered(t *testing.T) {
+	// Read JSON file into memory.
+	assert := require.New(t)
+	_, file, _, _ := runtime.Caller(0)
+	reply, err := ioutil.ReadFile(filepath.Join(filepath.Dir(file), "api", "repos_test.json"))
+	assert.NoError(err)
+
+	// Setup mock HTTP server.
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/user/repos" {
+			w.Write(reply)
+		} else {
+			w.Write([]byte("[]"))
+		}
+	}))
+	defer ts.Close()
+
+	// Run test.
+	cfg := config.Config{}
+	ghAPI := api.API{TestURL: ts.URL, Languages: []string{"python"}, MaxSize: 1000}
+	ghRepos := api.GitHubRepos{}
+	ghGists := api.GitHubGists{}
+	logs, _, _, err := testUtils.WithLogging(func() {
+		assert.NoError(Collect(&cfg, &ghAPI, &ghRepos, &api.GitHubRepos{}, &ghGists))
+	})
+	assert.NoError(err)
+
+	// Verify.
+	assert.Len(ghRepos, 1)
+	var messages []string
+	for _, entry := range logs.Entries {
+		messages = append(messages, entry.Message)
+	}
+	assert.Contains(messages, "Found 1 repo (0 private and 0 forks).")
+	assert.Contains(messages, "Skipped 2 repos (1 other language, 1 too large).")
+	assert.NotContains(messages, "--> 0 of them are archived.")
+}
+
 func TestCollectRateLimit(t *testing.T) {
 	// Read JSON file into memory.
 	assert := require.New(t)
@@ -231,18 +272,18 @@ func TestCollectRateLimit(t *testing.T) {
 	ghRepos := api.GitHubRepos{}
 	ghGists := api.GitHubGists{}
 	logs, stdout, stderr, err := testUtils.WithLogging(func() {
-		assert.NoError(Collect(&cfg, &ghAPI, &ghRepos, &ghGists))
+		assert.NoError(Collect(&cfg, &ghAPI, &ghRepos, &api.GitHubRepos{}, &ghGists))
 	})
 	assert.NoError(err)
 	assert.Empty(stderr)
-	assert.Equal("Press Enter to continue...", stdout)
+	assert.Empty(stdout)
 
 	// Verify logs.
 	assert.Equal("Found 3 repos (1 private and 1 fork).", logs.Entries[4].Message)
 	assert.Equal("--> 1 of them have wikis.", logs.Entries[5].Message)
 	assert.Equal("--> 2 of them have GitHub Issues.", logs.Entries[6].Message)
 	assert.Equal("Didn't find any GitHub Gists to backup.", logs.Entries[7].Message)
-	assert.Equal("Only 1 API query of 60 remain. This may interrupt the program.", logs.Entries[8].Message)
+	assert.Equal("Only 1 API query of 60 remain. The program will pause when they run out.", logs.Entries[8].Message)
 	assert.Equal("GitHub will reset the counter in 2 minutes.", logs.Entries[9].Message)
 }
 
@@ -326,6 +367,24 @@ func TestMainBadDest(t *testing.T) {
 	} else {
 		assert.Contains(stderr, "dest: no such file or directory")
 	}
+	_, err = os.Stat(filepath.Join(tmpdir, "dne"))
+	assert.True(os.IsNotExist(err)) // No report written into DESTINATION.
+
+	// Report written with --report.
+	reportFile := filepath.Join(tmpdir, "report.json")
+	_, _, err = testUtils.WithCapSys(func() {
+		testUtils.ResetLogger()
+		ret := Main([]string{"-O", reportFile, filepath.Join(tmpdir, "dne", "dest")}, "")
+		assert.Equal(1, ret)
+	})
+	assert.NoError(err)
+	data, err := ioutil.ReadFile(reportFile)
+	assert.NoError(err)
+	var report Report
+	assert.NoError(json.Unmarshal(data, &report))
+	assert.Equal(1, report.ExitCode)
+	assert.Contains(report.Error, "dest: ")
+	assert.Equal(config.Version, report.Version)
 }
 
 func TestMainTokenError(t *testing.T) {
@@ -336,10 +395,14 @@ func TestMainTokenError(t *testing.T) {
 	defer os.RemoveAll(tmpdir)
 	defer testUtils.ResetLogger()
 
-	stdout, stderr, err := testUtils.WithCapSys(func() {
-		testUtils.ResetLogger()
-		ret := Main([]string{tmpdir}, "")
-		assert.Equal(1, ret)
+	var stdout, stderr string
+	err = testUtils.WithoutTokenSources(func() {
+		stdout, stderr, err = testUtils.WithCapSys(func() {
+			testUtils.ResetLogger()
+			ret := Main([]string{tmpdir}, "")
+			assert.Equal(1, ret)
+		})
+		assert.NoError(err)
 	})
 
 	assert.NoError(err)
@@ -369,3 +432,122 @@ func TestMainReposGistsAPIError(t *testing.T) {
 	assert.Contains(stdout, "githubBackup "+config.Version)
 	assert.Contains(stderr, "Querying GitHub API for repositories failed")
 }
+
+func TestMainReport(t *testing.T) {
+	assert := require.New(t)
+
+	tmpdir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpdir)
+	defer testUtils.ResetLogger()
+	dest := filepath.Join(tmpdir, "dest")
+	reportFile := filepath.Join(tmpdir, "report.json")
+
+	// Setup mock HTTP server.
+	repo := `{"name": "%s", "owner": {"login": "me"}, "clone_url": %q, "size": 1, "fork": false, ` +
+		`"private": false, "has_issues": false, "has_wiki": false, "pushed_at": "2016-10-20T00:00:00Z", ` +
+		`"updated_at": "2016-10-20T00:00:00Z"}`
+	repos := []string{fmt.Sprintf(repo, "good", newSource(assert, filepath.Join(tmpdir, "good")))}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("X-RateLimit-Limit", "60")
+		w.Header().Add("X-RateLimit-Remaining", "50")
+		if r.URL.Path == "/users/me/repos" {
+			w.Write([]byte("[" + strings.Join(repos, ",") + "]"))
+		} else if strings.HasPrefix(r.URL.Path, "/repos/") && strings.Count(r.URL.Path, "/") == 3 {
+			w.Write([]byte(`{"name": "good"}`)) // Metadata.
+		} else {
+			w.Write([]byte("[]"))
+		}
+	}))
+	defer ts.Close()
+
+	run := func(expected int) Report {
+		err = testUtils.WithoutTokenSources(func() {
+			_, _, err = testUtils.WithCapSys(func() {
+				testUtils.ResetLogger()
+				assert.Equal(expected, Main([]string{"-Tume", "-O", reportFile, dest}, ts.URL))
+			})
+			assert.NoError(err)
+		})
+		assert.NoError(err)
+		data, err := ioutil.ReadFile(reportFile)
+		assert.NoError(err)
+		var report Report
+		assert.NoError(json.Unmarshal(data, &report))
+		return report
+	}
+
+	// Success.
+	report := run(0)
+	assert.Equal(0, report.ExitCode)
+	assert.Equal(config.Version, report.Version)
+	assert.Contains(report.GitVersion, "git version ")
+	assert.False(report.End.Before(report.Start))
+	assert.Equal("", report.Error)
+	assert.Equal(60, report.RateLimit.Limit)
+	assert.Equal(50, report.RateLimit.Remaining)
+	assert.Equal(5, report.RateLimit.Requests) // Repos, gists, metadata, pull requests and releases.
+	assert.Len(report.Repos, 1)
+	expected := reportItem{Name: "me/good", Dir: "good", Status: "created", Metadata: "exported",
+		Bytes: report.Repos[0].Bytes}
+	assert.Equal(expected, report.Repos[0])
+	assert.Empty(report.Gists)
+
+	// Partial failure.
+	repos = append(repos, fmt.Sprintf(repo, "bad", filepath.Join(tmpdir, "dne")))
+	report = run(3)
+	assert.Equal(3, report.ExitCode)
+	assert.Len(report.Repos, 2)
+	assert.Equal("unchanged", report.Repos[0].Status)
+	assert.Equal(int64(0), report.Repos[0].Bytes)
+	assert.Equal("failed", report.Repos[1].Status)
+	assert.Len(report.Repos[1].Errors, 1)
+
+	// Aborted.
+	repos = nil
+	report = run(1)
+	assert.Equal(1, report.ExitCode)
+	assert.Equal("no repos or gists to backup", report.Error)
+	assert.Empty(report.Repos)
+}
+
+func TestMainDryRun(t *testing.T) {
+	assert := require.New(t)
+
+	tmpdir, err := ioutil.TempDir("", "")
+	assert.NoError(err)
+	defer os.RemoveAll(tmpdir)
+	defer testUtils.ResetLogger()
+	dest := filepath.Join(tmpdir, "dest")
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/users/me/gists" {
+			w.Write([]byte(`[{"id": "abc123", "public": true, "comments": 0, "updated_at": "2016-10-20T00:00:00Z", ` +
+				`"git_pull_url": "https://gist.github.com/abc123.git", "files": {"a.txt": {"size": 5}}}]`))
+		} else {
+			w.Write([]byte("[]"))
+		}
+	}))
+	defer ts.Close()
+
+	var stdout, stderr string
+	err = testUtils.WithoutTokenSources(func() {
+		stdout, stderr, err = testUtils.WithCapSys(func() {
+			testUtils.ResetLogger()
+			assert.Equal(0, Main([]string{"-Tume", "--dry-run", "--json", dest}, ts.URL))
+		})
+		assert.NoError(err)
+	})
+	assert.NoError(err)
+
+	// Only the listing goes to stdout.
+	var items []planItem
+	assert.NoError(json.Unmarshal([]byte(stdout), &items), stdout)
+	cloneDir := filepath.Join(dest, "gists", "abc123-a-txt", "abc123.git")
+	assert.Equal([]planItem{{"gist", "abc123", "create", cloneDir, 5}}, items)
+	assert.Contains(stderr, "githubBackup "+config.Version)
+
+	// Nothing written.
+	_, err = os.Stat(dest)
+	assert.True(os.IsNotExist(err))
+}
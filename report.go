@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/Robpol86/githubBackup/api"
+	"github.com/Robpol86/githubBackup/clone"
+	"github.com/Robpol86/githubBackup/config"
+)
+
+const reportFile = "report.json"
+
+// Exit statuses of Main().
+const (
+	exitOK      = 0
+	exitFailed  = 1 // The backup couldn't run.
+	exitConfig  = 2 // Invalid options or log file.
+	exitPartial = 3 // Some repos or gists failed to back up.
+)
+
+// reportItem is the outcome of backing up one repo or gist. Wiki, Issues and Comments are empty if the item has none.
+type reportItem struct {
+	Name     string
+	Dir      string // Relative to the destination directory.
+	Status   string // Of the clone: created, updated, reset, skipped, unchanged or failed.
+	Wiki     string // Same as Status or missing.
+	Metadata string // exported or failed.
+	Issues   string // exported, skipped or failed.
+	Pulls    int    // Pull requests exported (new or updated since the last backup).
+	Comments string // saved or failed.
+	Assets   int    // Release assets downloaded.
+	Bytes    int64  // How much the item's directory grew, i.e. about how much was transferred.
+	Errors   []string
+}
+
+// reportRateLimit is the GitHub API usage of a run.
+type reportRateLimit struct {
+	Limit     int
+	Remaining int
+	Reset     time.Time
+	Requests  int
+}
+
+// Report is written as JSON at the end of every run for monitoring.
+type Report struct {
+	Start      time.Time
+	End        time.Time
+	Version    string
+	GitVersion string
+	GoVersion  string
+	ExitCode   int
+	Error      string // Why the backup couldn't run, empty otherwise.
+	RateLimit  reportRateLimit
+	Repos      []reportItem
+	Starred    []reportItem
+	Gists      []reportItem
+}
+
+// newReport starts the report of a run.
+func newReport(start time.Time) *Report {
+	report := &Report{Start: start, Version: config.Version, GoVersion: runtime.Version()}
+	if output, err := clone.Version(); err == nil {
+		report.GitVersion = output
+	}
+	return report
+}
+
+// reportPath returns where the report is written to.
+func reportPath(path, dest string) string {
+	if path != "" {
+		return path
+	}
+	return filepath.Join(dest, api.StateDir, reportFile)
+}
+
+// finish records the end of the run.
+func (r *Report) finish(exitCode int, ghAPI *api.API) {
+	r.End = time.Now()
+	r.ExitCode = exitCode
+	if ghAPI != nil {
+		r.RateLimit = reportRateLimit{ghAPI.Limit, ghAPI.Remaining, ghAPI.Reset.Time, ghAPI.Requests}
+	}
+}
+
+// write atomically saves the report as JSON.
+func (r *Report) write(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(data, '\n'))
+}
+
+// dirSize returns the total size of the files in a directory. Unreadable files are ignored.
+func dirSize(dir string) (size int64) {
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Robpol86/githubBackup/api"
+	"github.com/Robpol86/githubBackup/clone"
+)
+
+const stateFile = "state.json"
+
+// itemState is what's remembered about one local clone after it was successfully backed up.
+type itemState struct {
+	PushedAt  time.Time
+	UpdatedAt time.Time
+	Head      string
+}
+
+// backupState is persisted between runs so unchanged repos and gists aren't fetched again. Keys of Clones are clone
+// directories relative to the destination directory. Safe for concurrent use.
+type backupState struct {
+	Clones map[string]itemState
+	path   string
+	dest   string
+	mu     sync.Mutex
+}
+
+// loadState reads the state file of the previous run. A missing or unreadable state file just means everything is
+// fetched.
+func loadState(dest string) *backupState {
+	path := filepath.Join(dest, api.StateDir, stateFile)
+	state := &backupState{Clones: map[string]itemState{}, path: path, dest: dest}
+	if data, err := ioutil.ReadFile(state.path); err == nil {
+		if err := json.Unmarshal(data, state); err != nil || state.Clones == nil {
+			state.Clones = map[string]itemState{}
+		}
+	}
+	return state
+}
+
+// relDir returns a backup directory relative to the destination directory, with forward slashes on all platforms.
+func relDir(dest, dir string) string {
+	if rel, err := filepath.Rel(dest, dir); err == nil {
+		return filepath.ToSlash(rel)
+	}
+	return filepath.ToSlash(dir)
+}
+
+func (s *backupState) key(dir string) string {
+	return relDir(s.dest, dir)
+}
+
+// unchanged returns true if the remote repository wasn't pushed to since the last backup and the local clone is still
+// at the same commit.
+func (s *backupState) unchanged(dir string, pushedAt, updatedAt time.Time) bool {
+	s.mu.Lock()
+	previous, ok := s.Clones[s.key(dir)]
+	s.mu.Unlock()
+	if !ok || previous.Head == "" || !previous.PushedAt.Equal(pushedAt) || !previous.UpdatedAt.Equal(updatedAt) {
+		return false
+	}
+	head, err := clone.Head(dir)
+	return err == nil && head == previous.Head
+}
+
+// record remembers a successfully backed up clone. Forgets it if the backup failed.
+func (s *backupState) record(dir string, pushedAt, updatedAt time.Time, ok bool) {
+	var head string
+	if ok {
+		head, _ = clone.Head(dir)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := s.key(dir)
+	if !ok {
+		delete(s.Clones, key)
+		return
+	}
+	s.Clones[key] = itemState{PushedAt: pushedAt, UpdatedAt: updatedAt, Head: head}
+}
+
+// save atomically writes the state file.
+func (s *backupState) save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(s.path), os.ModePerm); err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, append(data, '\n'))
+}
+
+// writeFileAtomic writes to a temporary file first so readers never see a partially written file.
+func writeFileAtomic(path string, data []byte) error {
+	if err := ioutil.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
@@ -0,0 +1,42 @@
+package testUtils
+
+import (
+	"io/ioutil"
+	"os"
+)
+
+// WithEnv temporarily sets environment variables while the function runs. Empty values unset the variable.
+func WithEnv(env map[string]string, function func()) {
+	for key, value := range env {
+		if old, ok := os.LookupEnv(key); ok {
+			defer os.Setenv(key, old)
+		} else {
+			defer os.Unsetenv(key)
+		}
+		if value == "" {
+			os.Unsetenv(key)
+		} else {
+			os.Setenv(key, value)
+		}
+	}
+	function()
+}
+
+// WithoutTokenSources runs the function with no GitHub token in the environment and without the user's git config so
+// git credential helpers don't provide real tokens during tests.
+func WithoutTokenSources(function func()) error {
+	home, err := ioutil.TempDir("", "")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(home)
+	env := map[string]string{
+		"GITHUB_TOKEN":        "",
+		"GIT_CONFIG_NOSYSTEM": "1",
+		"HOME":                home,
+		"USERPROFILE":         home,
+		"XDG_CONFIG_HOME":     home,
+	}
+	WithEnv(env, function)
+	return nil
+}
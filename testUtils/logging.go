@@ -1,9 +1,11 @@
 package testUtils
 
 import (
+	"io/ioutil"
 	"log"
 	"os"
 	"regexp"
+	"sync"
 
 	"github.com/Robpol86/logrus-custom-formatter"
 	"github.com/Sirupsen/logrus"
@@ -37,15 +39,30 @@ func LogMsgs() {
 	logger.WithFields(logrus.Fields{"a": "b", "c": 10}).Error("Sample error 2.")
 }
 
-type setupLogging func(bool, bool, bool, bool, string) error
+// lockedHook serializes calls to a test hook. Logrus fires hooks without locking and the code under test may log from
+// several goroutines.
+type lockedHook struct {
+	*test.Hook
+	mu sync.Mutex
+}
+
+func (h *lockedHook) Fire(entry *logrus.Entry) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.Hook.Fire(entry)
+}
+
+type setupLogging func(bool, bool, bool, bool, bool, string) error
 
 // WithLogging wraps around WithCapSys(). It enables a test debug logger before calling the input function.
 func WithLogging(function func()) (hook *test.Hook, stdout, stderr string, err error) {
 	defer ResetLogger()
 	stdout, stderr, err = WithCapSys(func() {
-		var logger *logrus.Logger
-		logger, hook = test.NewNullLogger()
+		logger := logrus.New()
+		logger.Out = ioutil.Discard
 		logger.Level = logrus.DebugLevel
+		hook = new(test.Hook)
+		logger.Hooks.Add(&lockedHook{Hook: hook})
 		ResetLogger(logger)
 		function()
 	})